@@ -19,6 +19,9 @@ package ansible
 import (
 	"bufio"
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -33,12 +36,16 @@ import (
 	"time"
 
 	"github.com/ghodss/yaml"
+	"github.com/prometheus/client_golang/prometheus"
 	"k8s.io/kubernetes/pkg/api"
 	client "k8s.io/kubernetes/pkg/client/unversioned"
 	cmdutil "k8s.io/kubernetes/pkg/kubectl/cmd/util"
 
+	"github.com/fabric8io/kansible/health"
 	"github.com/fabric8io/kansible/k8s"
 	"github.com/fabric8io/kansible/log"
+	"github.com/fabric8io/kansible/metrics"
+	"github.com/fabric8io/kansible/ssh"
 )
 
 const (
@@ -48,12 +55,32 @@ const (
 	// HostInventoryAnnotation is the list of hosts from the inventory
 	HostInventoryAnnotation = "kansible.fabric8.io/host-inventory"
 
+	// SpecHashAnnotation is the hash of the template, replicas and inventory last applied to the RC so that it is
+	// only updated when they change or the RC has been changed outside of kansible
+	SpecHashAnnotation = "kansible.fabric8.io/spec-hash"
+
+	// LiveSpecHashAnnotation is the hash of the spec of the RC as stored by Kubernetes after it was last applied so
+	// that changes made outside of kansible, such as editing the template or scaling the RC, are reverted
+	LiveSpecHashAnnotation = "kansible.fabric8.io/live-spec-hash"
+
 	// HostNameAnnotation is used to annotate a pod with the host name its processing
 	HostNameAnnotation = "kansible.fabric8.io/host-name"
 
 	// HostAddressAnnotation is used to annotate a pod with the host address its processing
 	HostAddressAnnotation = "kansible.fabric8.io/host-address"
 
+	// HostLabel is used to label a pod with the name of the host it has claimed
+	HostLabel = "kansible.fabric8.io/host"
+
+	// RCLabel is used to label the resources such as Secrets which are generated for a ReplicationController
+	RCLabel = "kansible.fabric8.io/rc"
+
+	// GroupLabel is used to label a pod with the inventory hosts group of the host it has claimed
+	GroupLabel = "kansible.fabric8.io/group"
+
+	// HostVariableLabelPrefix is the label prefix used to label a pod with the selected inventory variables of its host
+	HostVariableLabelPrefix = "var.kansible.fabric8.io/"
+
 	// IconAnnotation is the annotation used to denote the icon on an RC or Service
 	IconAnnotation = "fabric8.io/iconUrl"
 
@@ -78,9 +105,94 @@ const (
 	// EnvExportEnvVars is the space separated list of environment variables exported to the remote process
 	EnvExportEnvVars = "KANSIBLE_EXPORT_ENV_VARS"
 
+	// EnvHostLabels is the space separated list of inventory host variables which are added as labels to the pod
+	EnvHostLabels = "KANSIBLE_HOST_LABELS"
+
+	// EnvResilient enables the resilient supervision mode for SSH hosts where the remote command is run detached from the
+	// SSH session so that it keeps running if the connection is dropped and the pod re-attaches to it after reconnecting
+	EnvResilient = "KANSIBLE_RESILIENT"
+
+	// EnvReconnectTimeout is the duration for which the pod keeps trying to reconnect to the host in resilient mode
+	EnvReconnectTimeout = "KANSIBLE_RECONNECT_TIMEOUT"
+
+	// EnvStopSignal is the signal sent to the remote process group of SSH hosts when the pod is terminated
+	EnvStopSignal = "KANSIBLE_STOP_SIGNAL"
+
+	// EnvStopGracePeriod is how long to wait for the remote process of SSH hosts to stop before its killed
+	EnvStopGracePeriod = "KANSIBLE_STOP_GRACE_PERIOD"
+
+	// EnvLogFiles is the space separated list of glob patterns of the log files on the host to tail to the pod's stdout
+	EnvLogFiles = "KANSIBLE_LOG_FILES"
+
+	// EnvLogFilePrefix disables prefixing each line of the tailed log files with the name of the file if its false
+	EnvLogFilePrefix = "KANSIBLE_LOG_FILE_PREFIX"
+
+	// EnvHealthPort is the port of the /healthz and /readyz endpoints of the kansible pod; 0 disables them
+	EnvHealthPort = "KANSIBLE_HEALTH_PORT"
+
+	// EnvProcessSampleInterval is how often the CPU and memory usage of the remote process is sampled for the
+	// metrics; if its blank the remote process is not sampled
+	EnvProcessSampleInterval = "KANSIBLE_PROCESS_SAMPLE_INTERVAL"
+
+	// EnvProcessName is the name of the process on Windows hosts whose CPU and memory usage is sampled
+	EnvProcessName = "KANSIBLE_PROCESS_NAME"
+
+	// EnvHostKeyChecking is how the host keys of SSH hosts are verified; either strict, tofu (trust on first use) or off
+	EnvHostKeyChecking = "KANSIBLE_HOST_KEY_CHECKING"
+
+	// EnvKnownHosts is the known_hosts file used to verify the host keys of SSH hosts
+	EnvKnownHosts = "KANSIBLE_KNOWN_HOSTS"
+
+	// EnvKnownHostsSecret is the name of the Secret containing the known_hosts of the SSH hosts to which the
+	// host keys trusted on first use are added
+	EnvKnownHostsSecret = "KANSIBLE_KNOWN_HOSTS_SECRET"
+
+	// EnvSSHKeyPassphrase is the passphrase of passphrase protected SSH private keys which is usually populated from a Secret
+	EnvSSHKeyPassphrase = "KANSIBLE_SSH_KEY_PASSPHRASE"
+
+	// EnvSSHConnectTimeout is how long to wait for the connection and SSH handshake with a host; e.g. 30s
+	EnvSSHConnectTimeout = "KANSIBLE_SSH_CONNECT_TIMEOUT"
+
+	// EnvSSHKeepAliveInterval is how often keepalives are sent over the SSH connections; 0 disables them
+	EnvSSHKeepAliveInterval = "KANSIBLE_SSH_KEEPALIVE_INTERVAL"
+
+	// EnvSSHKeepAliveCountMax is how many keepalives in a row can go unanswered before an SSH connection is closed
+	EnvSSHKeepAliveCountMax = "KANSIBLE_SSH_KEEPALIVE_COUNT_MAX"
+
+	// EnvBecomePassword is the password of the become method for hosts without an ansible_become_pass variable
+	// which is usually populated from a Secret
+	EnvBecomePassword = "KANSIBLE_BECOME_PASS"
+
+	// EnvShellType is the shell type of the hosts without an ansible_shell_type variable
+	EnvShellType = "KANSIBLE_SHELL_TYPE"
+
+	// EnvShellExecutable is the shell executable of SSH hosts without an ansible_shell_executable variable
+	EnvShellExecutable = "KANSIBLE_SHELL_EXECUTABLE"
+
+	// EnvWorkingDir is the directory in which the remote command is run on hosts without a kansible_working_dir variable
+	EnvWorkingDir = "KANSIBLE_WORKING_DIR"
+
+	// EnvPty enables or disables requesting a pseudo terminal for the remote command on SSH hosts; by default a
+	// pseudo terminal is only requested for the shell opened via the EnvBash script
+	EnvPty = "KANSIBLE_PTY"
+
 	// EnvPortForward allows port forwarding to be disabled
 	EnvPortForward = "KANSIBLE_PORT_FORWARD"
 
+	// EnvReversePorts is the space or comma separated list of ports in the `[bindAddress:]remotePort:host:port`
+	// format which are listened on by SSH hosts and forwarded to hosts reachable from the pod such as services
+	EnvReversePorts = "KANSIBLE_REVERSE_PORTS"
+
+	// EnvPortForwardMode is how the ports are forwarded to the host; either PortForwardModeTCP or PortForwardModeSSH
+	EnvPortForwardMode = "KANSIBLE_PORT_FORWARD_MODE"
+
+	// PortForwardModeTCP forwards the ports by connecting to the ports of the host directly or through its jump hosts
+	PortForwardModeTCP = "tcp"
+
+	// PortForwardModeSSH forwards the ports over an SSH connection to the host like `ssh -L` so that only the SSH
+	// port of the host needs to be reachable and the ports can be bound to the loopback interface of the host
+	PortForwardModeSSH = "ssh"
+
 	// EnvBash is the environment variable on a pod for the name of the bash script to generate on startup for
 	// opening a remote shell
 	EnvBash = "KANSIBLE_BASH"
@@ -110,9 +222,65 @@ const (
 	// AnsibleVariablePassword is the Ansible inventory host variable for the password
 	AnsibleVariablePassword = "ansible_ssh_pass"
 
+	// AnsibleVariableSSHCommonArgs is the Ansible inventory host variable for the extra arguments of ssh such as
+	// the ProxyJump or ProxyCommand options for connecting through a jump host
+	AnsibleVariableSSHCommonArgs = "ansible_ssh_common_args"
+
+	// AnsibleVariableSSHExtraArgs is the Ansible inventory host variable for the extra arguments of ssh only
+	AnsibleVariableSSHExtraArgs = "ansible_ssh_extra_args"
+
+	// AnsibleVariableShellType is the Ansible inventory host variable for the kind of shell on the host; e.g. 'sh',
+	// 'csh' or 'fish' for the login shell of SSH hosts or 'cmd' or 'powershell' for windows
+	AnsibleVariableShellType = "ansible_shell_type"
+
+	// AnsibleVariableShellExecutable is the Ansible inventory host variable for the POSIX shell which runs the
+	// commands on SSH hosts
+	AnsibleVariableShellExecutable = "ansible_shell_executable"
+
+	// AnsibleVariableBecome is the Ansible inventory host variable which enables running the remote command, probes
+	// and log tailers as the become user
+	AnsibleVariableBecome = "ansible_become"
+
+	// AnsibleVariableBecomeUser is the Ansible inventory host variable for the become user which defaults to root
+	AnsibleVariableBecomeUser = "ansible_become_user"
+
+	// AnsibleVariableBecomeMethod is the Ansible inventory host variable for how to become the user; one of sudo,
+	// su, pbrun or dzdo
+	AnsibleVariableBecomeMethod = "ansible_become_method"
+
+	// AnsibleVariableBecomePassword is the Ansible inventory host variable for the password of the become method
+	AnsibleVariableBecomePassword = "ansible_become_pass"
+
+	// KansibleVariableJumpHost is the inventory host variable for the comma separated list of jump hosts in the
+	// `[user@]host[:port]` format through which the SSH connections to the host are tunneled
+	KansibleVariableJumpHost = "kansible_jump_host"
+
+	// KansibleVariableJumpUser is the inventory host variable for the user of the jump hosts
+	KansibleVariableJumpUser = "kansible_jump_user"
+
+	// KansibleVariableJumpPrivateKey is the inventory host variable for the SSH private key file of the jump hosts
+	KansibleVariableJumpPrivateKey = "kansible_jump_private_key_file"
+
+	// KansibleVariableWorkingDir is the inventory host variable for the directory on the host in which the remote
+	// command is run
+	KansibleVariableWorkingDir = "kansible_working_dir"
+
+	// KansibleVariablePty is the inventory host variable which enables or disables requesting a pseudo terminal for
+	// the remote command on the host overriding EnvPty
+	KansibleVariablePty = "kansible_pty"
+
 	// ConnectionWinRM is the value AnsibleVariableConnection of for using Windows with WinRM
 	ConnectionWinRM = "winrm"
 
+	// ConnectionSSH is the value for AnsibleVariableConnection which is the default connection type
+	ConnectionSSH = "ssh"
+
+	// ShellTypeCmd is the value of AnsibleVariableShellType for cmd which is the default for windows hosts
+	ShellTypeCmd = "cmd"
+
+	// ShellTypePowerShell is the value of AnsibleVariableShellType for PowerShell on windows hosts
+	ShellTypePowerShell = "powershell"
+
 	// AppRunCommand is the Ansible inventory host variable for the run command that is executed on the remote host
 	AppRunCommand = "app_run_command"
 
@@ -130,6 +298,10 @@ type HostEntry struct {
 	Connection string
 	Password   string
 	RunCommand string
+	Variables  map[string]string
+
+	// Slot is the slot on the host claimed by this pod
+	Slot int
 }
 
 // LoadHostEntries loads the Ansible inventory for a given hosts string value
@@ -199,6 +371,11 @@ func LoadHostEntriesFromText(text string) ([]*HostEntry, error) {
 // and chooses a single host inside it, returning the host name and the private key
 func ChooseHostAndPrivateKey(thisPodName string, hosts string, c *client.Client, ns string, rcName string) (*HostEntry, *api.ReplicationController, map[string]string, error) {
 	retryAttempts := 20
+	log.SetFields(log.Fields{
+		log.FieldPod:       thisPodName,
+		log.FieldNamespace: ns,
+		log.FieldRC:        rcName,
+	})
 
 	for i := 0; i < retryAttempts; i++ {
 		if i > 0 {
@@ -216,7 +393,7 @@ func ChooseHostAndPrivateKey(thisPodName string, hosts string, c *client.Client,
 			return nil, nil, nil, fmt.Errorf("No ReplicationController found for name %s", rcName)
 		}
 
-		pods, err := c.Pods(ns).List(api.ListOptions{})
+		pods, err := k8s.GetPodsForReplicationController(c, ns, rc)
 		if err != nil {
 			return nil, nil, nil, err
 		}
@@ -239,34 +416,39 @@ func ChooseHostAndPrivateKey(thisPodName string, hosts string, c *client.Client,
 		}
 		log.Info("Found %d host entries", len(hostEntries))
 
-		// lets pick a random entry
-		if len(hostEntries) > 0 {
-			filteredHostEntries := hostEntries
-			for annKey, podName := range annotations {
-				if strings.HasPrefix(annKey, AnsibleHostPodAnnotationPrefix) {
-					hostName := annKey[len(AnsibleHostPodAnnotationPrefix):]
-					if k8s.PodIsRunning(pods, podName) {
-						if podName != thisPodName {
-							log.Info("Pod %s podName has already claimed host %s", podName, hostName)
-							filteredHostEntries = removeHostEntry(filteredHostEntries, hostName)
-						}
-					} else {
-						// lets remove this annotation as the pod is no longer valid
-						log.Info("Pod %s is no longer running so removing the annotation %s", podName, annKey)
-						delete(metadata.Annotations, annKey)
-					}
-				}
+		if IsManagedByController(rc) {
+			pickedEntry := assignedHostEntry(annotations, hostEntries, thisPodName)
+			if pickedEntry == nil {
+				log.Info("Waiting for the kansible controller to assign a host to this pod")
+				continue
 			}
+			log.Info("The kansible controller assigned host %s", pickedEntry.Host)
+			metrics.ClaimAttempt(metrics.ClaimResultClaimed)
+			return onHostClaimed(c, ns, thisPodName, hosts, rc, pods, hostEntries, pickedEntry)
+		}
+
+		// lets pick an entry spreading the claimed hosts across the zones
+		if len(hostEntries) > 0 {
+			claims := loadHostClaims(annotations, pods, hostEntries, thisPodName)
+			removeStaleHostClaims(c, ns, annotations, claims)
+			filteredHostEntries := claims.available(hostEntries)
 
 			count := len(filteredHostEntries)
 
 			if count == 0 {
 				log.Info("There are no more hosts available to be supervised by this pod!")
+				metrics.ClaimAttempt(metrics.ClaimResultUnavailable)
+				if IsReplicasPerHost(rc) && matchReplicasToCapacity(rc, hostEntries) {
+					_, err = c.ReplicationControllers(ns).Update(rc)
+					if err != nil {
+						log.Warn("Failed to scale the RC, could be concurrent update failure: %s", err)
+					}
+				}
 				return nil, nil, nil, fmt.Errorf("No more hosts available to be supervised!")
 			}
 			log.Info("After filtering out hosts owned by other pods we have %v host entries left", count)
 
-			pickedEntry := filteredHostEntries[random(0, count)]
+			pickedEntry := pickHostEntry(filteredHostEntries, claims.entries)
 			hostName := pickedEntry.Name
 			if len(pickedEntry.Host) == 0 {
 				return nil, nil, nil, fmt.Errorf("Could not find host name for entry %s", pickedEntry.Name)
@@ -275,56 +457,167 @@ func ChooseHostAndPrivateKey(thisPodName string, hosts string, c *client.Client,
 				return nil, nil, nil, fmt.Errorf("Could not find User for entry %s", pickedEntry.Name)
 			}
 
+			pickedEntry.Slot = firstFreeSlot(claims.slots[hostName])
+
 			// lets try pick this pod
-			annotations[AnsibleHostPodAnnotationPrefix+hostName] = thisPodName
+			annotations[HostClaimAnnotation(hostName, pickedEntry.Slot)] = thisPodName
+			if IsReplicasPerHost(rc) {
+				matchReplicasToCapacity(rc, hostEntries)
+			}
 
 			rc, err = c.ReplicationControllers(ns).Update(rc)
 			if err != nil {
 				log.Info("Failed to update the RC, could be concurrent update failure: %s", err)
+				metrics.ClaimAttempt(metrics.ClaimResultConflict)
 			} else {
-				log.Info("Picked host " + pickedEntry.Host)
+				log.Info("Picked host %s", pickedEntry.Host)
+				metrics.ClaimAttempt(metrics.ClaimResultClaimed)
+				return onHostClaimed(c, ns, thisPodName, hosts, rc, pods, hostEntries, pickedEntry)
+			}
+		}
+	}
+	return nil, nil, nil, fmt.Errorf("Could not find any available hosts on the ReplicationController %s and hosts %s", rcName, hosts)
+}
 
-				// lets update the Pod with the host name label
-				podClient := c.Pods(ns)
-				pod, err := podClient.Get(thisPodName)
-				if err != nil {
-					return pickedEntry, nil, nil, err
-				}
-				metadata := &pod.ObjectMeta
-				if metadata.Annotations == nil {
-					metadata.Annotations = make(map[string]string)
-				}
-				metadata.Annotations[HostNameAnnotation] = pickedEntry.Name
-				metadata.Annotations[HostAddressAnnotation] = pickedEntry.Host
-				//pod.Status = api.PodStatus{}
-				pod, err = podClient.UpdateStatus(pod)
-				if err != nil {
-					return pickedEntry, nil, nil, err
-				}
+// onHostClaimed updates this pod with the details of the host it has claimed then starts forwarding ports to the host
+func onHostClaimed(c *client.Client, ns string, thisPodName string, hosts string, rc *api.ReplicationController, pods *api.PodList, hostEntries []*HostEntry, pickedEntry *HostEntry) (*HostEntry, *api.ReplicationController, map[string]string, error) {
+	// lets include the claimed host in the context of all further log messages
+	log.SetFields(pickedEntry.LogFields())
 
-				// lets export required environment variables
-				exportEnvVars := os.Getenv(EnvExportEnvVars)
-				envVars := make(map[string]string)
-				if len(exportEnvVars) > 0 {
-					names := strings.Split(exportEnvVars, " ")
-					for _, name := range names {
-						name = strings.TrimSpace(name)
-						if len(name) > 0 {
-							value := os.Getenv(name)
-							if len(value) > 0 {
-								envVars[name] = value
-								log.Debug("Exporting environment variable %s = %s", name, value)
-							}
-						}
-					}
-				}
+	// lets update the Pod with the host name label
+	podClient := c.Pods(ns)
+	pod, err := podClient.Get(thisPodName)
+	if err != nil {
+		return pickedEntry, nil, nil, err
+	}
+	metadata := &pod.ObjectMeta
+	if metadata.Annotations == nil {
+		metadata.Annotations = make(map[string]string)
+	}
+	metadata.Annotations[HostNameAnnotation] = pickedEntry.Name
+	metadata.Annotations[HostAddressAnnotation] = pickedEntry.Host
+	if pickedEntry.Slot > 1 {
+		metadata.Annotations[HostSlotAnnotation] = strconv.Itoa(pickedEntry.Slot)
+	} else {
+		delete(metadata.Annotations, HostSlotAnnotation)
+	}
+	metadata.Labels = replaceHostLabels(metadata.Labels, hostLabels(pickedEntry, hosts))
+	//pod.Status = api.PodStatus{}
+	pod, err = podClient.Update(pod)
+	if err != nil {
+		return pickedEntry, nil, nil, err
+	}
+
+	// lets export required environment variables
+	envVars := exportEnvVars(pod)
+
+	// lets tunnel the connections to the host through its jump hosts
+	jumpHosts, err := pickedEntry.JumpHosts()
+	if err != nil {
+		return pickedEntry, rc, envVars, err
+	}
+	ssh.SetJumpHosts(pickedEntry.Host, jumpHosts)
 
-				err = forwardPorts(pod, pickedEntry)
-				return pickedEntry, rc, envVars, err
+	become, err := pickedEntry.Become()
+	if err != nil {
+		return pickedEntry, rc, envVars, err
+	}
+	ssh.SetBecome(pickedEntry.Host, become)
+
+	shell, err := pickedEntry.Shell()
+	if err != nil {
+		return pickedEntry, rc, envVars, err
+	}
+	ssh.SetShell(pickedEntry.Host, shell)
+
+	err = forwardPorts(pod, pickedEntry)
+	return pickedEntry, rc, envVars, err
+}
+
+// exportEnvVars returns the environment variables listed in EnvExportEnvVars which are to be exported to the
+// remote process; the values of those which come from a Secret are masked in the log messages
+func exportEnvVars(pod *api.Pod) map[string]string {
+	envVars := make(map[string]string)
+	for _, name := range strings.Split(os.Getenv(EnvExportEnvVars), " ") {
+		name = strings.TrimSpace(name)
+		if len(name) == 0 {
+			continue
+		}
+		value := os.Getenv(name)
+		if len(value) > 0 {
+			envVars[name] = value
+			if k8s.IsEnvVarFromSecret(pod, name) {
+				value = ssh.MaskedValue
 			}
+			log.Debug("Exporting environment variable %s = %s", name, value)
 		}
 	}
-	return nil, nil, nil, fmt.Errorf("Could not find any available hosts on the ReplicationController %s and hosts %s", rcName, hosts)
+	return envVars
+}
+
+// hostLabels returns the labels used to identify the given host on the pod which has claimed it
+func hostLabels(hostEntry *HostEntry, hosts string) map[string]string {
+	answer := map[string]string{
+		HostLabel:  k8s.ToLabelValue(hostEntry.Name),
+		GroupLabel: k8s.ToLabelValue(hosts),
+	}
+	names := strings.Split(os.Getenv(EnvHostLabels), " ")
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if len(name) > 0 {
+			value := hostEntry.GetVariable(name)
+			if len(value) > 0 {
+				answer[HostVariableLabelPrefix+name] = k8s.ToLabelValue(value)
+			}
+		}
+	}
+	return answer
+}
+
+// isHostLabel returns true if the given label key is used to identify the claimed host of a pod
+func isHostLabel(key string) bool {
+	return key == HostLabel || key == GroupLabel || strings.HasPrefix(key, HostVariableLabelPrefix)
+}
+
+// replaceHostLabels removes any previous host labels from the given labels then adds the new host labels
+func replaceHostLabels(labels map[string]string, newLabels map[string]string) map[string]string {
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	for k := range labels {
+		if isHostLabel(k) {
+			delete(labels, k)
+		}
+	}
+	for k, v := range newLabels {
+		labels[k] = v
+	}
+	return labels
+}
+
+// releasePodHostLabels removes the host labels from the given pod after its claim on a host has been released
+func releasePodHostLabels(c *client.Client, ns string, podName string) {
+	podClient := c.Pods(ns)
+	pod, err := podClient.Get(podName)
+	if err != nil || pod == nil {
+		log.Debug("Could not find pod %s to remove its host labels: %v", podName, err)
+		return
+	}
+	labels := pod.ObjectMeta.Labels
+	found := false
+	for k := range labels {
+		if isHostLabel(k) {
+			found = true
+		}
+	}
+	if !found {
+		return
+	}
+	pod.ObjectMeta.Labels = replaceHostLabels(labels, nil)
+	_, err = podClient.Update(pod)
+	if err != nil {
+		log.Debug("Failed to remove the host labels from pod %s: %v", podName, err)
+	}
 }
 
 // forwardPorts forwards any ports that are defined in the PodSpec to the host
@@ -337,6 +630,32 @@ func forwardPorts(pod *api.Pod, hostEntry *HostEntry) error {
 	}
 	podSpec := pod.Spec
 	host := hostEntry.Host
+	dial := ssh.DialTunnel
+	mode := strings.ToLower(strings.TrimSpace(os.Getenv(EnvPortForwardMode)))
+	switch mode {
+	case "", PortForwardModeTCP:
+	case PortForwardModeSSH:
+		if hostEntry.Connection == ConnectionWinRM {
+			log.Warn("Cannot forward ports over SSH to the WinRM host %s so connecting to the ports directly", hostEntry.Name)
+			break
+		}
+		sshPort := hostEntry.Port
+		if len(sshPort) == 0 {
+			sshPort = "22"
+		}
+		forwarder := &ssh.Forwarder{
+			User:       hostEntry.User,
+			PrivateKey: hostEntry.PrivateKey,
+			Password:   hostEntry.Password,
+			Host:       host,
+			Port:       sshPort,
+		}
+		dial = forwarder.Dial
+		host = "127.0.0.1"
+	default:
+		return fmt.Errorf("Unknown port forward mode `%s` in $%s; expected %s or %s", mode, EnvPortForwardMode, PortForwardModeTCP, PortForwardModeSSH)
+	}
+	health.SetPortDialer(dial)
 	for _, container := range podSpec.Containers {
 		for _, port := range container.Ports {
 			name := port.Name
@@ -344,7 +663,10 @@ func forwardPorts(pod *api.Pod, hostEntry *HostEntry) error {
 			if portNum > 0 {
 				address := "0.0.0.0:" + strconv.Itoa(portNum)
 				forwardAddress := host + ":" + strconv.Itoa(portNum)
-				err := forwardPortLoop(name, address, forwardAddress)
+				health.AddForwardedPort(name, forwardAddress)
+				fields := hostEntry.LogFields()
+				fields[log.FieldPort] = strconv.Itoa(portNum)
+				err := forwardPortLoop(log.WithFields(fields), name, strconv.Itoa(portNum), address, forwardAddress, dial)
 				if err != nil {
 					return err
 				}
@@ -354,60 +676,167 @@ func forwardPorts(pod *api.Pod, hostEntry *HostEntry) error {
 	return nil
 }
 
-func forwardPortLoop(name string, address string, forwardAddress string) error {
-	log.Info("forwarding port %s %s => %s", name, address, forwardAddress)
+func forwardPortLoop(logger *log.Logger, name string, port string, address string, forwardAddress string, dial func(string) (net.Conn, error)) error {
+	logger.Info("forwarding port %s %s => %s", name, address, forwardAddress)
 	listener, err := net.Listen("tcp", address)
 	if err != nil {
 		return err
 	}
 
-	log.Info("About to start the acceptor goroutine!")
+	logger.Info("About to start the acceptor goroutine!")
 	go func() {
 		for {
 			conn, err := listener.Accept()
 			if err != nil {
-				log.Err("Failed to accept listener: %v", err)
+				logger.Err("Failed to accept listener: %v", err)
+				continue
 			}
-			log.Info("Accepted connection %v\n", conn)
-			go forwardPort(conn, forwardAddress)
+			logger.Info("Accepted connection from %s", conn.RemoteAddr())
+			metrics.ForwardedConnection(port)
+			go forwardPort(logger, conn, port, forwardAddress, dial)
 		}
 	}()
 	return nil
 }
 
-func forwardPort(conn net.Conn, address string) {
-	client, err := net.Dial("tcp", address)
+func forwardPort(logger *log.Logger, conn net.Conn, port string, address string, dial func(string) (net.Conn, error)) {
+	client, err := dial(address)
 	if err != nil {
-		log.Err("Dial failed: %v", err)
+		logger.Err("Dial failed: %v", err)
+		conn.Close()
+		return
 	}
-	log.Info("Connected to localhost %v\n", conn)
+	logger.Info("Connected to %s for %s", address, conn.RemoteAddr())
 	go func() {
 		defer client.Close()
 		defer conn.Close()
-		io.Copy(client, conn)
+		io.Copy(&countingWriter{client, metrics.ForwardedBytes(port, "in")}, conn)
 	}()
 	go func() {
 		defer client.Close()
 		defer conn.Close()
-		io.Copy(conn, client)
+		io.Copy(&countingWriter{conn, metrics.ForwardedBytes(port, "out")}, client)
 	}()
 }
 
+// countingWriter counts the bytes written to the forwarded port
+type countingWriter struct {
+	writer  io.Writer
+	counter prometheus.Counter
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	w.counter.Add(float64(n))
+	return n, err
+}
+
 // UpdateKansibleRC reads the Ansible inventory and the RC YAML for the hosts and updates it in Kubernetes
 // along with removing any remaining pods which are running against old hosts that have been removed from the inventory
-func UpdateKansibleRC(hostEntries []*HostEntry, hosts string, f *cmdutil.Factory, c *client.Client, ns string, rcFile string, replicas int) (*api.ReplicationController, error) {
-	variables, err := LoadAnsibleVariables(hosts)
+// and storing the known_hosts lines of the SSH hosts in a Secret used to verify their host keys; strictly if
+// knownHostsComplete is true. If perHost is true the replicas track the capacity of the inventory; otherwise if clamp is true then any replicas
+// above the capacity of the inventory are reduced to the capacity rather than failing
+func UpdateKansibleRC(hostEntries []*HostEntry, hosts string, f *cmdutil.Factory, c *client.Client, ns string, rcFile string, replicas int, perHost bool, clamp bool, knownHosts []string, knownHostsComplete bool) (*api.ReplicationController, error) {
+	rcConfig, variables, err := LoadKansibleRC(hosts, rcFile)
 	if err != nil {
 		return nil, err
 	}
-	data, err := LoadFileAndReplaceVariables(rcFile, variables)
+	err = checkBecomePassword(hostEntries, rcConfig)
 	if err != nil {
 		return nil, err
 	}
-	rcConfig, err := k8s.ReadReplicationController(data)
+	err = generatePrivateKeySecrets(c, ns, hostEntries, rcConfig)
 	if err != nil {
 		return nil, err
 	}
+	err = generateKnownHostsSecret(c, ns, knownHosts, knownHostsComplete, rcConfig)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := ApplyKansibleRC(c, ns, rcConfig, hostEntries, replicas, perHost, clamp)
+	if err != nil {
+		return nil, err
+	}
+	err = applyOtherKubernetesResources(f, c, ns, rcFile, variables)
+	return rc, err
+}
+
+// UpdateKansibleApp reads the Ansible inventory and the RC YAML for the hosts and creates or updates the
+// KansibleApp custom resource so that the kansible controller can reconcile the RC. The replicas, perHost and clamp
+// arguments are stored in the KansibleApp and used like those of UpdateKansibleRC
+func UpdateKansibleApp(hostEntries []*HostEntry, hosts string, f *cmdutil.Factory, c *client.Client, ns string, rcFile string, replicas int, perHost bool, clamp bool, knownHosts []string, knownHostsComplete bool) (*k8s.KansibleApp, error) {
+	rcConfig, variables, err := LoadKansibleRC(hosts, rcFile)
+	if err != nil {
+		return nil, err
+	}
+	err = checkBecomePassword(hostEntries, rcConfig)
+	if err != nil {
+		return nil, err
+	}
+	err = generatePrivateKeySecrets(c, ns, hostEntries, rcConfig)
+	if err != nil {
+		return nil, err
+	}
+	err = generateKnownHostsSecret(c, ns, knownHosts, knownHostsComplete, rcConfig)
+	if err != nil {
+		return nil, err
+	}
+	name := rcConfig.ObjectMeta.Name
+	spec := k8s.KansibleAppSpec{
+		Hosts:         hosts,
+		Inventory:     HostEntriesToString(hostEntries),
+		PerHost:       perHost,
+		ClampReplicas: clamp,
+		Template:      *rcConfig,
+	}
+	if replicas >= 0 {
+		spec.Replicas = &replicas
+	}
+
+	app, err := k8s.GetKansibleApp(c, ns, name)
+	if err != nil || app == nil {
+		log.Info("Creating KansibleApp %s", name)
+		app, err = k8s.CreateKansibleApp(c, ns, &k8s.KansibleApp{
+			ObjectMeta: api.ObjectMeta{
+				Name:   name,
+				Labels: rcConfig.ObjectMeta.Labels,
+			},
+			Spec: spec,
+		})
+	} else {
+		log.Info("Updating KansibleApp %s", name)
+		app.Spec = spec
+		app, err = k8s.UpdateKansibleApp(c, ns, app)
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = applyOtherKubernetesResources(f, c, ns, rcFile, variables)
+	return app, err
+}
+
+// LoadKansibleRC loads the RC YAML for the hosts replacing any Ansible variables and then
+// configures it to run the kansible pods
+func LoadKansibleRC(hosts string, rcFile string) (*api.ReplicationController, map[string]string, error) {
+	variables, err := LoadAnsibleVariables(hosts)
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := LoadFileAndReplaceVariables(rcFile, variables)
+	if err != nil {
+		return nil, nil, err
+	}
+	rcConfig, err := k8s.ReadReplicationController(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = ConfigureKansibleRC(rcConfig, hosts, "ReplicationController YAML file "+rcFile)
+	return rcConfig, variables, err
+}
+
+// ConfigureKansibleRC defaults the labels, selectors, container and environment variables of the
+// RC so that it runs the kansible pods for the hosts. The source describes where the RC came from
+func ConfigureKansibleRC(rcConfig *api.ReplicationController, hosts string, source string) error {
 	rcName := rcConfig.ObjectMeta.Name
 	podSpec := k8s.GetOrCreatePodSpec(rcConfig)
 
@@ -440,17 +869,36 @@ func UpdateKansibleRC(hostEntries []*HostEntry, hosts string, f *cmdutil.Factory
 	if len(podSpec.ServiceAccountName) == 0 {
 		podSpec.ServiceAccountName = rcName
 	}
-	serviceAccountName := podSpec.ServiceAccountName
 	k8s.EnsureContainerHasPreStopCommand(container, preStopCommands)
+	healthPort := health.DefaultPort
+	healthPortText := k8s.GetContainerEnvVar(container, EnvHealthPort)
+	if len(healthPortText) > 0 {
+		port, err := strconv.Atoi(healthPortText)
+		if err != nil {
+			return fmt.Errorf("Invalid port number for %s in %s: %s", EnvHealthPort, source, err)
+		}
+		healthPort = port
+	}
+	if healthPort > 0 {
+		k8s.EnsureContainerHasHTTPProbes(container, healthPort, health.HealthzPath, health.ReadyzPath)
+	}
 	k8s.EnsureContainerHasEnvVar(container, EnvHosts, hosts)
 	k8s.EnsureContainerHasEnvVar(container, EnvRC, rcName)
 	k8s.EnsureContainerHasEnvVar(container, EnvBash, "/usr/local/bin/bash")
 	k8s.EnsureContainerHasEnvVarFromField(container, EnvNamespace, "metadata.namespace")
 	command := k8s.GetContainerEnvVar(container, EnvCommand)
 	if len(command) == 0 {
-		return nil, fmt.Errorf("No environemnt variable value defined for %s in ReplicationController YAML file %s", EnvCommand, rcFile)
+		return fmt.Errorf("No environemnt variable value defined for %s in %s", EnvCommand, source)
 	}
+	return nil
+}
 
+// ApplyKansibleRC creates or updates the given RC configuration in Kubernetes for the host entries
+// along with removing any remaining pods which are running against old hosts that have been removed from the inventory
+func ApplyKansibleRC(c *client.Client, ns string, rcConfig *api.ReplicationController, hostEntries []*HostEntry, replicas int, perHost bool, clamp bool) (*api.ReplicationController, error) {
+	rcName := rcConfig.ObjectMeta.Name
+	podSpec := k8s.GetOrCreatePodSpec(rcConfig)
+	serviceAccountName := podSpec.ServiceAccountName
 	if len(serviceAccountName) > 0 {
 		created, err := k8s.EnsureServiceAccountExists(c, ns, serviceAccountName)
 		if err != nil {
@@ -465,7 +913,8 @@ func UpdateKansibleRC(hostEntries []*HostEntry, hosts string, f *cmdutil.Factory
 	}
 
 	isUpdate := true
-	rc, err := c.ReplicationControllers(ns).Get(rcName)
+	current, err := c.ReplicationControllers(ns).Get(rcName)
+	var rc *api.ReplicationController
 	if err != nil {
 		isUpdate = false
 		rc = &api.ReplicationController{
@@ -474,8 +923,14 @@ func UpdateKansibleRC(hostEntries []*HostEntry, hosts string, f *cmdutil.Factory
 				Name:      rcName,
 			},
 		}
+	} else {
+		copied, err := api.Scheme.DeepCopy(current)
+		if err != nil {
+			return nil, err
+		}
+		rc = copied.(*api.ReplicationController)
 	}
-	pods, err := c.Pods(ns).List(api.ListOptions{})
+	pods, err := k8s.GetPodsForReplicationController(c, ns, rcConfig)
 	if err != nil {
 		return nil, err
 	}
@@ -487,20 +942,35 @@ func UpdateKansibleRC(hostEntries []*HostEntry, hosts string, f *cmdutil.Factory
 	metadata := &rc.ObjectMeta
 	resourceVersion := metadata.ResourceVersion
 	rcSpec := &rc.Spec
-	if replicas < 0 {
-		replicas = originalReplicas
+	if metadata.Annotations == nil {
+		metadata.Annotations = make(map[string]string)
 	}
-	rcSpec.Replicas = replicas
-
-	err = generatePrivateKeySecrets(c, ns, hostEntries, rc, podSpec, container)
-	if err != nil {
-		return rc, err
+	for k, v := range rcConfig.ObjectMeta.Annotations {
+		metadata.Annotations[k] = v
 	}
+	capacity := HostCapacity(hostEntries)
+	if replicas < 0 && IsReplicasPerHost(rc) {
+		perHost = true
+	}
+	if perHost {
+		log.Info("Using one replica per host slot for a capacity of %d", capacity)
+		metadata.Annotations[ReplicasPerHostAnnotation] = "true"
+		replicas = capacity
+	} else {
+		delete(metadata.Annotations, ReplicasPerHostAnnotation)
+		if replicas < 0 {
+			// the existing replicas may be above the capacity if hosts have been removed from the inventory
+			replicas = originalReplicas
+			clamp = true
+		}
+		replicas, err = validateReplicas(replicas, capacity, clamp)
+		if err != nil {
+			return nil, err
+		}
+	}
+	rcSpec.Replicas = replicas
 
 	text := HostEntriesToString(hostEntries)
-	if metadata.Annotations == nil {
-		metadata.Annotations = make(map[string]string)
-	}
 	metadata.Annotations[HostInventoryAnnotation] = text
 	metadata.Annotations[IconAnnotation] = IconURL
 
@@ -508,19 +978,82 @@ func UpdateKansibleRC(hostEntries []*HostEntry, hosts string, f *cmdutil.Factory
 
 	deletePodsForOldHosts(c, ns, metadata.Annotations, pods, hostEntries)
 
+	hash, err := specHash(rcSpec, rcConfig.ObjectMeta.Annotations, text, perHost)
+	if err != nil {
+		return nil, err
+	}
+	if isUpdate && metadata.Annotations[SpecHashAnnotation] == hash {
+		liveHash, err := liveSpecHash(&current.Spec)
+		if err != nil {
+			return nil, err
+		}
+		if metadata.Annotations[LiveSpecHashAnnotation] == liveHash {
+			log.Debug("The RC %s is up to date", rcName)
+			return current, nil
+		}
+		log.Info("The RC %s has been changed outside of kansible so updating it", rcName)
+	}
+	metadata.Annotations[SpecHashAnnotation] = hash
+
 	replicationController := c.ReplicationControllers(ns)
 	if isUpdate {
-		_, err = replicationController.Update(rc)
+		deleteSurplusPods(c, ns, metadata.Annotations, pods, hostEntries, replicas)
+		rc, err = replicationController.Update(rc)
 	} else {
-		_, err = replicationController.Create(rc)
+		rc, err = replicationController.Create(rc)
 	}
 	if err != nil {
 		log.Info("Failed to update the RC, could be concurrent update failure: %s", err)
 		return nil, err
 	}
+	return recordLiveSpecHash(c, ns, rc)
+}
 
-	err = applyOtherKubernetesResources(f, c, ns, rcFile, variables)
-	return rc, err
+// recordLiveSpecHash annotates the RC with the hash of its spec as stored by Kubernetes, along with any defaults
+// it has applied, so that later changes to the RC made outside of kansible, such as editing the template or
+// scaling it, can be detected
+func recordLiveSpecHash(c *client.Client, ns string, rc *api.ReplicationController) (*api.ReplicationController, error) {
+	liveHash, err := liveSpecHash(&rc.Spec)
+	if err != nil {
+		return nil, err
+	}
+	if rc.ObjectMeta.Annotations[LiveSpecHashAnnotation] == liveHash {
+		return rc, nil
+	}
+	rc.ObjectMeta.Annotations[LiveSpecHashAnnotation] = liveHash
+	updated, err := c.ReplicationControllers(ns).Update(rc)
+	if err != nil {
+		// the RC is updated again the next time as the live hash does not match
+		log.Info("Failed to record the spec hash of the RC, could be concurrent update failure: %s", err)
+		return rc, nil
+	}
+	return updated, nil
+}
+
+// liveSpecHash returns the hash of the spec of the RC as stored by Kubernetes
+func liveSpecHash(spec *api.ReplicationControllerSpec) (string, error) {
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// specHash returns the hash of the desired state of the RC; its spec along with the annotations and inventory
+// which are applied to it
+func specHash(spec *api.ReplicationControllerSpec, annotations map[string]string, inventory string, perHost bool) (string, error) {
+	data, err := json.Marshal(struct {
+		Spec        *api.ReplicationControllerSpec
+		Annotations map[string]string
+		Inventory   string
+		PerHost     bool
+	}{spec, annotations, inventory, perHost})
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:]), nil
 }
 
 func applyOtherKubernetesResources(f *cmdutil.Factory, c *client.Client, ns string, rcFile string, variables map[string]string) error {
@@ -710,53 +1243,113 @@ func runCommand(binary string, args []string, reader io.Reader) error {
 	return err
 }
 
-func generatePrivateKeySecrets(c *client.Client, ns string, hostEntries []*HostEntry, rc *api.ReplicationController, podSpec *api.PodSpec, container *api.Container) error {
+// checkBecomePassword returns an error if the inventory specifies the become password via ansible_become_pass, which
+// is never stored in the RC or KansibleApp, unless the RC populates KANSIBLE_BECOME_PASS from a Secret instead; or
+// if the RC specifies KANSIBLE_BECOME_PASS as a plain value
+func checkBecomePassword(hostEntries []*HostEntry, rc *api.ReplicationController) error {
+	fromSecret := false
+	if rc.Spec.Template != nil {
+		for _, container := range rc.Spec.Template.Spec.Containers {
+			for _, env := range container.Env {
+				if env.Name != EnvBecomePassword {
+					continue
+				}
+				if env.ValueFrom == nil || env.ValueFrom.SecretKeyRef == nil {
+					return fmt.Errorf("The %s environment variable of container %s must be populated from a Secret via valueFrom.secretKeyRef rather than specifying the password in the RC YAML", EnvBecomePassword, container.Name)
+				}
+				fromSecret = true
+			}
+		}
+	}
+	for _, hostEntry := range hostEntries {
+		if len(hostEntry.Variables[AnsibleVariableBecomePassword]) == 0 {
+			continue
+		}
+		if !fromSecret {
+			return fmt.Errorf("Host %s specifies the password via %s which is not stored in the RC; populate the %s environment variable of the RC from a Secret via valueFrom.secretKeyRef instead", hostEntry.Name, AnsibleVariableBecomePassword, EnvBecomePassword)
+		}
+		log.Warn("Ignoring %s of host %s as the pods use the %s environment variable from a Secret", AnsibleVariableBecomePassword, hostEntry.Name, EnvBecomePassword)
+	}
+	return nil
+}
+
+func generatePrivateKeySecrets(c *client.Client, ns string, hostEntries []*HostEntry, rc *api.ReplicationController) error {
 	secrets := map[string]string{}
 	rcName := rc.ObjectMeta.Name
+	podSpec := k8s.GetOrCreatePodSpec(rc)
+	container := k8s.GetFirstContainerOrCreate(rc)
+	labels := map[string]string{}
+	for k, v := range rc.ObjectMeta.Labels {
+		labels[k] = v
+	}
+	labels[RCLabel] = rcName
+
+	// mountPrivateKey stores the private key file in a Secret mounted in the pod and returns its path in the pod
+	mountPrivateKey := func(name string, privateKey string) (string, error) {
+		keyName := "sshkey"
+		volumeMount := secrets[privateKey]
+		if len(volumeMount) > 0 {
+			return volumeMount + "/" + keyName, nil
+		}
+		buffer, err := ioutil.ReadFile(privateKey)
+		if err != nil {
+			return "", err
+		}
+		secretName := rcName + "-" + name
+		secret := &api.Secret{
+			ObjectMeta: api.ObjectMeta{
+				Name:   secretName,
+				Labels: labels,
+			},
+			Data: map[string][]byte{
+				keyName: buffer,
+			},
+		}
 
-	for _, hostEntry := range hostEntries {
-		privateKey := hostEntry.PrivateKey
-		if len(privateKey) != 0 {
-			volumeMount := secrets[privateKey]
-			if len(volumeMount) == 0 {
-				buffer, err := ioutil.ReadFile(privateKey)
-				if err != nil {
-					return err
-				}
-				hostName := hostEntry.Name
-				secretName := rcName + "-" + hostName
-				keyName := "sshkey"
-				secret := &api.Secret{
-					ObjectMeta: api.ObjectMeta{
-						Name:   secretName,
-						Labels: rc.ObjectMeta.Labels,
-					},
-					Data: map[string][]byte{
-						keyName: buffer,
-					},
-				}
+		// lets include the OpenSSH user certificate of the key if there is one
+		cert, err := ioutil.ReadFile(privateKey + ssh.CertificateSuffix)
+		if err == nil {
+			secret.Data[keyName+ssh.CertificateSuffix] = cert
+		}
 
-				// lets create or update the secret
-				secretClient := c.Secrets(ns)
-				current, err := secretClient.Get(secretName)
-				if err != nil || current == nil {
-					_, err = secretClient.Create(secret)
-				} else {
-					_, err = secretClient.Update(secret)
-				}
-				if err != nil {
-					return err
-				}
+		// lets create or update the secret
+		secretClient := c.Secrets(ns)
+		current, err := secretClient.Get(secretName)
+		if err != nil || current == nil {
+			_, err = secretClient.Create(secret)
+		} else {
+			_, err = secretClient.Update(secret)
+		}
+		if err != nil {
+			return "", err
+		}
 
-				volumeMount = "/secrets/" + hostName
-				secrets[privateKey] = volumeMount
-				hostEntry.PrivateKey = volumeMount + "/" + keyName
+		volumeMount = "/secrets/" + name
+		secrets[privateKey] = volumeMount
+
+		// lets add the volume mapping to the container
+		secretVolumeName := "secret-" + name
+		k8s.EnsurePodSpecHasSecretVolume(podSpec, secretVolumeName, secretName)
+		k8s.EnsureContainerHasVolumeMount(container, secretVolumeName, volumeMount)
+		return volumeMount + "/" + keyName, nil
+	}
 
-				// lets add the volume mapping to the container
-				secretVolumeName := "secret-" + hostName
-				k8s.EnsurePodSpecHasSecretVolume(podSpec, secretVolumeName, secretName)
-				k8s.EnsureContainerHasVolumeMount(container, secretVolumeName, volumeMount)
+	for _, hostEntry := range hostEntries {
+		privateKey := hostEntry.PrivateKey
+		if len(privateKey) != 0 {
+			path, err := mountPrivateKey(hostEntry.Name, privateKey)
+			if err != nil {
+				return err
+			}
+			hostEntry.PrivateKey = path
+		}
+		jumpPrivateKey := hostEntry.Variables[KansibleVariableJumpPrivateKey]
+		if len(jumpPrivateKey) != 0 {
+			path, err := mountPrivateKey(hostEntry.Name+"-jump", jumpPrivateKey)
+			if err != nil {
+				return err
 			}
+			hostEntry.Variables[KansibleVariableJumpPrivateKey] = path
 		}
 	}
 	return nil
@@ -782,19 +1375,6 @@ func findGitURL() (string, error) {
 	return "", nil
 }
 
-func removeHostEntry(hostEntries []*HostEntry, name string) []*HostEntry {
-	for i, entry := range hostEntries {
-		if entry.Name == name {
-			if i < len(hostEntries)-1 {
-				return append(hostEntries[:i], hostEntries[i+1:]...)
-			}
-			return hostEntries[:i]
-		}
-	}
-	log.Warn("Did not find a host entry with name %s", name)
-	return hostEntries
-}
-
 // GetHostEntryByName finds the HostEntry for the given host name or returns nil
 func GetHostEntryByName(hostEntries []*HostEntry, name string) *HostEntry {
 	for _, entry := range hostEntries {
@@ -807,8 +1387,8 @@ func GetHostEntryByName(hostEntries []*HostEntry, name string) *HostEntry {
 
 func deletePodsForOldHosts(c *client.Client, ns string, annotations map[string]string, pods *api.PodList, hostEntries []*HostEntry) {
 	for annKey, podName := range annotations {
-		if strings.HasPrefix(annKey, AnsibleHostPodAnnotationPrefix) {
-			hostName := annKey[len(AnsibleHostPodAnnotationPrefix):]
+		hostName, _, ok := parseHostClaimAnnotation(annKey)
+		if ok {
 			if k8s.PodIsRunning(pods, podName) {
 				hostEntry := GetHostEntryByName(hostEntries, hostName)
 				if hostEntry == nil {
@@ -825,7 +1405,7 @@ func random(min, max int) int {
 	return rand.Intn(max-min) + min
 }
 
-// HostEntriesToString generates the Ansible inventory text for the host entries
+// HostEntriesToString generates the Ansible inventory text for the host entries without any ansible_become_pass
 func HostEntriesToString(hostEntries []*HostEntry) string {
 	var buffer bytes.Buffer
 	for _, hostEntry := range hostEntries {
@@ -842,54 +1422,234 @@ func (hostEntry HostEntry) write(buffer *bytes.Buffer) {
 		buffer.WriteString(" ")
 		buffer.WriteString(AnsibleVariableHost)
 		buffer.WriteString("=")
-		buffer.WriteString(host)
+		buffer.WriteString(quoteVariable(host))
 	}
 	pk := hostEntry.PrivateKey
 	if len(pk) > 0 {
 		buffer.WriteString(" ")
 		buffer.WriteString(AnsibleVariablePrivateKey)
 		buffer.WriteString("=")
-		buffer.WriteString(pk)
+		buffer.WriteString(quoteVariable(pk))
 	}
 	password := hostEntry.Password
 	if len(password) > 0 {
 		buffer.WriteString(" ")
 		buffer.WriteString(AnsibleVariablePassword)
 		buffer.WriteString("=")
-		buffer.WriteString(password)
+		buffer.WriteString(quoteVariable(password))
 	}
 	runCommand := hostEntry.RunCommand
 	if len(runCommand) > 0 {
 		buffer.WriteString(" ")
 		buffer.WriteString(AppRunCommand)
 		buffer.WriteString("=")
-		buffer.WriteString(runCommand)
+		buffer.WriteString(quoteVariable(runCommand))
 	}
 	port := hostEntry.Port
 	if len(port) > 0 {
 		buffer.WriteString(" ")
 		buffer.WriteString(AnsibleVariablePort)
 		buffer.WriteString("=")
-		buffer.WriteString(port)
+		buffer.WriteString(quoteVariable(port))
 	}
 	user := hostEntry.User
 	if len(user) > 0 {
 		buffer.WriteString(" ")
 		buffer.WriteString(AnsibleVariableUser)
 		buffer.WriteString("=")
-		buffer.WriteString(user)
+		buffer.WriteString(quoteVariable(user))
 	}
 	connection := hostEntry.Connection
 	if len(connection) > 0 {
 		buffer.WriteString(" ")
 		buffer.WriteString(AnsibleVariableConnection)
 		buffer.WriteString("=")
-		buffer.WriteString(connection)
+		buffer.WriteString(quoteVariable(connection))
+	}
+	names := []string{}
+	for name := range hostEntry.Variables {
+		names = append(names, name)
 	}
+	sort.Strings(names)
+	for _, name := range names {
+		// the become password is never stored in the RC annotation or the KansibleApp; the pods use $KANSIBLE_BECOME_PASS
+		if name == AnsibleVariableBecomePassword {
+			continue
+		}
+		buffer.WriteString(" ")
+		buffer.WriteString(name)
+		buffer.WriteString("=")
+		buffer.WriteString(quoteVariable(hostEntry.Variables[name]))
+	}
+}
+
+// quoteVariable quotes the value of an inventory variable if it contains whitespace or quotes so that
+// splitInventoryLine reads it back unchanged; single quotes are written inside double quotes
+func quoteVariable(value string) string {
+	if !strings.ContainsAny(value, " \t'\"") {
+		return value
+	}
+	return "'" + strings.Replace(value, "'", `'"'"'`, -1) + "'"
+}
+
+// splitInventoryLine splits an inventory line into the host name and its variables respecting quoted values
+func splitInventoryLine(text string) []string {
+	values := []string{}
+	var value []rune
+	inValue := false
+	var quote rune
+	for _, r := range text {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				value = append(value, r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inValue = true
+		case r == ' ' || r == '\t':
+			if inValue {
+				values = append(values, string(value))
+				value = nil
+				inValue = false
+			}
+		default:
+			value = append(value, r)
+			inValue = true
+		}
+	}
+	if inValue {
+		values = append(values, string(value))
+	}
+	return values
+}
+
+// JumpHosts returns the jump hosts through which the SSH connections to the host are tunneled from the
+// kansible_jump_host variable or the ProxyJump or ProxyCommand options of the ssh arguments
+func (hostEntry *HostEntry) JumpHosts() ([]ssh.JumpHost, error) {
+	if hostEntry.Connection == ConnectionWinRM {
+		return []ssh.JumpHost{}, nil
+	}
+	user := hostEntry.Variables[KansibleVariableJumpUser]
+	if len(user) == 0 {
+		user = hostEntry.User
+	}
+	privateKey := hostEntry.Variables[KansibleVariableJumpPrivateKey]
+	if len(privateKey) == 0 {
+		privateKey = hostEntry.PrivateKey
+	}
+	jumpHost := hostEntry.Variables[KansibleVariableJumpHost]
+	if len(jumpHost) > 0 {
+		return ssh.ParseJumpHosts(jumpHost, user, privateKey, hostEntry.Password)
+	}
+	args := hostEntry.Variables[AnsibleVariableSSHCommonArgs] + " " + hostEntry.Variables[AnsibleVariableSSHExtraArgs]
+	jumpHosts, err := ssh.ParseProxyArgs(args, user, privateKey, hostEntry.Password)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid ssh arguments for host %s: %s", hostEntry.Name, err)
+	}
+	return jumpHosts, nil
+}
+
+// Become returns how the remote command, probes and log tailers are run as a different user from the
+// ansible_become variables of the host; the password defaults to $KANSIBLE_BECOME_PASS. Returns nil if
+// ansible_become is not enabled
+func (hostEntry *HostEntry) Become() (*ssh.Become, error) {
+	if hostEntry.Connection == ConnectionWinRM || !isTrue(hostEntry.Variables[AnsibleVariableBecome]) {
+		return nil, nil
+	}
+	password := hostEntry.variableOrEnv(AnsibleVariableBecomePassword, EnvBecomePassword)
+	become, err := ssh.NewBecome(hostEntry.Variables[AnsibleVariableBecomeMethod], hostEntry.Variables[AnsibleVariableBecomeUser], password)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid become settings for host %s: %s", hostEntry.Name, err)
+	}
+	return become, nil
+}
+
+// Shell returns the shell which runs the commands on an SSH host from the ansible_shell_type,
+// ansible_shell_executable and kansible_working_dir variables of the host or their environment variables.
+// Returns nil if none are specified so that the commands are passed to the login shell as they are
+func (hostEntry *HostEntry) Shell() (*ssh.Shell, error) {
+	if hostEntry.Connection == ConnectionWinRM {
+		return nil, nil
+	}
+	shellType := hostEntry.ShellType()
+	executable := hostEntry.ShellExecutable()
+	workingDir := hostEntry.WorkingDir()
+	if len(shellType) == 0 && len(executable) == 0 && len(workingDir) == 0 {
+		return nil, nil
+	}
+	shell, err := ssh.NewShell(shellType, executable, workingDir)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid shell settings for host %s: %s", hostEntry.Name, err)
+	}
+	return shell, nil
+}
+
+// ShellType returns the ansible_shell_type of the host defaulting to $KANSIBLE_SHELL_TYPE
+func (hostEntry *HostEntry) ShellType() string {
+	return hostEntry.variableOrEnv(AnsibleVariableShellType, EnvShellType)
+}
+
+// ShellExecutable returns the ansible_shell_executable of the host defaulting to $KANSIBLE_SHELL_EXECUTABLE
+func (hostEntry *HostEntry) ShellExecutable() string {
+	return hostEntry.variableOrEnv(AnsibleVariableShellExecutable, EnvShellExecutable)
+}
+
+// WorkingDir returns the directory in which the remote command is run from the kansible_working_dir variable of the
+// host defaulting to $KANSIBLE_WORKING_DIR
+func (hostEntry *HostEntry) WorkingDir() string {
+	return hostEntry.variableOrEnv(KansibleVariableWorkingDir, EnvWorkingDir)
+}
+
+func (hostEntry *HostEntry) variableOrEnv(name string, envName string) string {
+	value := hostEntry.Variables[name]
+	if len(value) == 0 {
+		value = os.Getenv(envName)
+	}
+	return value
+}
+
+// isTrue returns true if the inventory variable value is one of the boolean true values of Ansible
+func isTrue(value string) bool {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "true", "yes", "on", "1", "y", "t":
+		return true
+	}
+	return false
+}
+
+// LogFields returns the fields for the host used as the context of log messages
+func (hostEntry *HostEntry) LogFields() log.Fields {
+	connection := hostEntry.Connection
+	if len(connection) == 0 {
+		connection = ConnectionSSH
+	}
+	return log.Fields{
+		log.FieldHost:       hostEntry.ClaimName(),
+		log.FieldAddress:    hostEntry.Host,
+		log.FieldConnection: connection,
+	}
+}
+
+// GetVariable returns the value of the given inventory variable for this host
+func (hostEntry *HostEntry) GetVariable(name string) string {
+	switch name {
+	case AnsibleVariableHost:
+		return hostEntry.Host
+	case AnsibleVariableUser:
+		return hostEntry.User
+	case AnsibleVariablePort:
+		return hostEntry.Port
+	case AnsibleVariableConnection:
+		return hostEntry.Connection
+	}
+	return hostEntry.Variables[name]
 }
 
 func parseHostEntry(text string) *HostEntry {
-	values := strings.Split(text, " ")
+	values := splitInventoryLine(text)
 	name := ""
 	user := ""
 	host := ""
@@ -898,13 +1658,14 @@ func parseHostEntry(text string) *HostEntry {
 	connection := ""
 	password := ""
 	runCommand := ""
+	variables := map[string]string{}
 	count := len(values)
 	if count > 0 {
 		name = values[0]
 
 		// lets parse the key value expressions for the host name
 		for _, exp := range values[1:] {
-			params := strings.Split(exp, "=")
+			params := strings.SplitN(exp, "=", 2)
 			if len(params) == 2 {
 				paramValue := params[1]
 				switch params[0] {
@@ -922,6 +1683,8 @@ func parseHostEntry(text string) *HostEntry {
 					password = paramValue
 				case AppRunCommand:
 					runCommand = paramValue
+				default:
+					variables[params[0]] = paramValue
 				}
 			}
 		}
@@ -940,5 +1703,6 @@ func parseHostEntry(text string) *HostEntry {
 		Connection: connection,
 		Password:   password,
 		RunCommand: runCommand,
+		Variables:  variables,
 	}
 }
@@ -0,0 +1,170 @@
+/*
+ * Copyright 2016 Red Hat
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ansible
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/kubernetes/pkg/api"
+
+	"github.com/fabric8io/kansible/log"
+	"github.com/fabric8io/kansible/ssh"
+)
+
+func TestQuoteVariableRoundTrip(t *testing.T) {
+	values := []string{
+		"",
+		"plain",
+		"/opt/my app/bin/run",
+		"it's",
+		`a"b`,
+		`say "it's done"`,
+		"'quoted'",
+		`"double quoted"`,
+		"tab\tseparated",
+		"a=b c=d",
+		`''`,
+	}
+	for _, value := range values {
+		line := "app1 name=" + quoteVariable(value)
+		actual := splitInventoryLine(line)
+		expected := []string{"app1", "name=" + value}
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("Value %q was written as `%s` which splits into %q", value, line, actual)
+		}
+	}
+}
+
+func TestHostEntriesRoundTrip(t *testing.T) {
+	hostEntries := []*HostEntry{
+		{
+			Name:       "app1",
+			Host:       "10.0.0.1",
+			Port:       "2222",
+			User:       "deploy",
+			PrivateKey: "/keys/my key",
+			Password:   `pa$$ "word" it's`,
+			RunCommand: "/opt/app/bin/run --name 'app 1'",
+			Variables: map[string]string{
+				"kansible_zone":        "eu-west",
+				"kansible_working_dir": "~/my app",
+				"motd":                 `it's "quoted"`,
+			},
+		},
+		{
+			Name:       "app2",
+			Host:       "app2",
+			Connection: ConnectionWinRM,
+			Variables:  map[string]string{},
+		},
+	}
+	loaded, err := LoadHostEntriesFromText(HostEntriesToString(hostEntries))
+	if err != nil {
+		t.Fatalf("Failed to load the host entries: %s", err)
+	}
+	if !reflect.DeepEqual(loaded, hostEntries) {
+		t.Errorf("Expected the host entries %+v but got %+v", hostEntries, loaded)
+	}
+}
+
+func TestExportEnvVarsMasksSecretValuesInTheLog(t *testing.T) {
+	pod := &api.Pod{
+		Spec: api.PodSpec{
+			Containers: []api.Container{
+				{
+					Env: []api.EnvVar{
+						{Name: "KANSIBLE_TEST_PLAIN", Value: "plain-value"},
+						{
+							Name: "KANSIBLE_TEST_SECRET",
+							ValueFrom: &api.EnvVarSource{
+								SecretKeyRef: &api.SecretKeySelector{
+									LocalObjectReference: api.LocalObjectReference{Name: "my-secret"},
+									Key:                  "password",
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	os.Setenv(EnvExportEnvVars, "KANSIBLE_TEST_PLAIN  KANSIBLE_TEST_SECRET KANSIBLE_TEST_MISSING")
+	os.Setenv("KANSIBLE_TEST_PLAIN", "plain-value")
+	os.Setenv("KANSIBLE_TEST_SECRET", "s3cr3t-value")
+	defer func() {
+		os.Unsetenv(EnvExportEnvVars)
+		os.Unsetenv("KANSIBLE_TEST_PLAIN")
+		os.Unsetenv("KANSIBLE_TEST_SECRET")
+	}()
+
+	var buffer bytes.Buffer
+	defer func(output io.Writer, debugging bool, minLevel log.Level) {
+		log.Output = output
+		log.IsDebugging = debugging
+		log.MinLevel = minLevel
+	}(log.Output, log.IsDebugging, log.MinLevel)
+	log.Output = &buffer
+	log.IsDebugging = true
+	log.MinLevel = log.LevelDebug
+
+	envVars := exportEnvVars(pod)
+	expected := map[string]string{
+		"KANSIBLE_TEST_PLAIN":  "plain-value",
+		"KANSIBLE_TEST_SECRET": "s3cr3t-value",
+	}
+	if !reflect.DeepEqual(envVars, expected) {
+		t.Errorf("Expected the environment variables %v but got %v", expected, envVars)
+	}
+	output := buffer.String()
+	if strings.Contains(output, "s3cr3t-value") {
+		t.Errorf("The value of the environment variable from a Secret was logged: %s", output)
+	}
+	if !strings.Contains(output, "KANSIBLE_TEST_SECRET = "+ssh.MaskedValue) {
+		t.Errorf("Expected the value of the environment variable from a Secret to be masked but got: %s", output)
+	}
+	if !strings.Contains(output, "KANSIBLE_TEST_PLAIN = plain-value") {
+		t.Errorf("Expected the value of the plain environment variable to be logged but got: %s", output)
+	}
+}
+
+func TestHostEntriesToStringOmitsBecomePassword(t *testing.T) {
+	hostEntries := []*HostEntry{
+		{
+			Name: "app1",
+			Host: "app1",
+			Variables: map[string]string{
+				AnsibleVariableBecome:         "true",
+				AnsibleVariableBecomePassword: "secret",
+			},
+		},
+	}
+	loaded, err := LoadHostEntriesFromText(HostEntriesToString(hostEntries))
+	if err != nil {
+		t.Fatalf("Failed to load the host entries: %s", err)
+	}
+	if len(loaded) != 1 || loaded[0].Variables[AnsibleVariableBecome] != "true" {
+		t.Fatalf("Expected the host entry with its variables but got %+v", loaded)
+	}
+	if _, ok := loaded[0].Variables[AnsibleVariableBecomePassword]; ok {
+		t.Errorf("Expected %s not to be written", AnsibleVariableBecomePassword)
+	}
+}
@@ -0,0 +1,191 @@
+/*
+ * Copyright 2016 Red Hat
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ansible
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/kubernetes/pkg/api"
+	client "k8s.io/kubernetes/pkg/client/unversioned"
+
+	"github.com/fabric8io/kansible/log"
+)
+
+const (
+	// AnsibleVariableSlots is the Ansible inventory host variable for the number of pods which can claim a host
+	AnsibleVariableSlots = "kansible_slots"
+
+	// HostSlotAnnotation is used to annotate a pod with the slot it has claimed on its host if the host has more than one slot
+	HostSlotAnnotation = "kansible.fabric8.io/host-slot"
+
+	// ReplicasPerHostAnnotation is used on the RC to indicate that its replicas track the capacity of the inventory
+	ReplicasPerHostAnnotation = "kansible.fabric8.io/replicas-per-host"
+
+	// slotAnnotationPrefix is the prefix of the host claim annotation prefix for the slots after the first slot
+	slotAnnotationPrefix = "slot"
+
+	// replicasCheckInterval is how often the pods check that the replicas of an RC which tracks the capacity of
+	// its inventory have not been changed by hand
+	replicasCheckInterval = time.Minute
+)
+
+// Slots returns the number of pods which can claim the host
+func (hostEntry *HostEntry) Slots() int {
+	text := hostEntry.Variables[AnsibleVariableSlots]
+	if len(text) == 0 {
+		return 1
+	}
+	slots, err := strconv.Atoi(text)
+	if err != nil || slots < 1 {
+		log.Warn("Ignoring invalid %s value `%s` on host %s", AnsibleVariableSlots, text, hostEntry.Name)
+		return 1
+	}
+	return slots
+}
+
+// ClaimName returns the name of the slot on the host claimed by this pod which is the host name
+// for the first slot so that hosts with a single slot are named as before
+func (hostEntry *HostEntry) ClaimName() string {
+	if hostEntry.Slot > 1 {
+		return hostEntry.Name + "." + slotAnnotationPrefix + strconv.Itoa(hostEntry.Slot)
+	}
+	return hostEntry.Name
+}
+
+// PodClaimName returns the name of the host (or the slot on the host) claimed by the pod with the given annotations
+func PodClaimName(annotations map[string]string) string {
+	hostEntry := HostEntry{
+		Name: annotations[HostNameAnnotation],
+	}
+	slotText := annotations[HostSlotAnnotation]
+	if len(slotText) > 0 {
+		slot, err := strconv.Atoi(slotText)
+		if err != nil {
+			log.Warn("Ignoring invalid annotation %s value `%s`: %s", HostSlotAnnotation, slotText, err)
+		} else {
+			hostEntry.Slot = slot
+		}
+	}
+	return hostEntry.ClaimName()
+}
+
+// HostClaimAnnotation returns the annotation key used on the RC to associate the slot of a host with a pod name
+func HostClaimAnnotation(hostName string, slot int) string {
+	if slot > 1 {
+		return slotAnnotationPrefix + strconv.Itoa(slot) + "." + AnsibleHostPodAnnotationPrefix + hostName
+	}
+	return AnsibleHostPodAnnotationPrefix + hostName
+}
+
+// parseHostClaimAnnotation returns the host name and slot of the given host claim annotation key
+// or false if the key is not a host claim annotation
+func parseHostClaimAnnotation(key string) (string, int, bool) {
+	if strings.HasPrefix(key, AnsibleHostPodAnnotationPrefix) {
+		return key[len(AnsibleHostPodAnnotationPrefix):], 1, true
+	}
+	if !strings.HasPrefix(key, slotAnnotationPrefix) {
+		return "", 0, false
+	}
+	idx := strings.Index(key, "."+AnsibleHostPodAnnotationPrefix)
+	if idx < 0 {
+		return "", 0, false
+	}
+	slot, err := strconv.Atoi(key[len(slotAnnotationPrefix):idx])
+	if err != nil || slot < 2 {
+		return "", 0, false
+	}
+	return key[idx+1+len(AnsibleHostPodAnnotationPrefix):], slot, true
+}
+
+// firstFreeSlot returns the lowest slot number which is not in the given claimed slots
+func firstFreeSlot(claimedSlots map[int]bool) int {
+	slot := 1
+	for claimedSlots[slot] {
+		slot++
+	}
+	return slot
+}
+
+// HostCapacity returns the total number of pods which can claim the given hosts
+func HostCapacity(hostEntries []*HostEntry) int {
+	answer := 0
+	for _, hostEntry := range hostEntries {
+		answer += hostEntry.Slots()
+	}
+	return answer
+}
+
+// IsReplicasPerHost returns true if the replicas of the given RC track the capacity of its inventory
+func IsReplicasPerHost(rc *api.ReplicationController) bool {
+	annotations := rc.ObjectMeta.Annotations
+	return annotations != nil && strings.ToLower(annotations[ReplicasPerHostAnnotation]) == "true"
+}
+
+// validateReplicas returns the number of replicas to use for the given capacity of the inventory.
+// If the replicas exceed the capacity they are either clamped or an error is returned
+func validateReplicas(replicas int, capacity int, clamp bool) (int, error) {
+	if replicas <= capacity {
+		return replicas, nil
+	}
+	if !clamp {
+		return replicas, fmt.Errorf("Cannot use %d replicas as the inventory only has capacity for %d pods", replicas, capacity)
+	}
+	log.Warn("Reducing the replicas from %d to %d which is the capacity of the inventory", replicas, capacity)
+	return capacity, nil
+}
+
+// matchReplicasToCapacity scales the RC up or down to the capacity of its inventory returning true if the RC
+// was changed
+func matchReplicasToCapacity(rc *api.ReplicationController, hostEntries []*HostEntry) bool {
+	capacity := HostCapacity(hostEntries)
+	if rc.Spec.Replicas == capacity {
+		return false
+	}
+	log.Info("Scaling ReplicationController %s from %d to %d replicas to match the capacity of the inventory", rc.ObjectMeta.Name, rc.Spec.Replicas, capacity)
+	rc.Spec.Replicas = capacity
+	return true
+}
+
+// MaintainReplicasPerHost periodically scales the RC back to the capacity of its inventory if its replicas track
+// the capacity but it has been scaled by hand, such as via `kubectl scale`. The replicas of RCs managed by the
+// kansible controller are maintained by the controller
+func MaintainReplicasPerHost(c *client.Client, ns string, rcName string) {
+	for {
+		time.Sleep(replicasCheckInterval)
+		rc, err := c.ReplicationControllers(ns).Get(rcName)
+		if err != nil {
+			log.Debug("Failed to load ReplicationController %s to check its replicas: %s", rcName, err)
+			continue
+		}
+		if !IsReplicasPerHost(rc) || IsManagedByController(rc) {
+			continue
+		}
+		hostEntries, err := LoadHostEntriesFromText(rc.ObjectMeta.Annotations[HostInventoryAnnotation])
+		if err != nil || len(hostEntries) == 0 {
+			continue
+		}
+		if matchReplicasToCapacity(rc, hostEntries) {
+			_, err = c.ReplicationControllers(ns).Update(rc)
+			if err != nil {
+				log.Warn("Failed to scale the RC, could be concurrent update failure: %s", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,173 @@
+/*
+ * Copyright 2016 Red Hat
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ansible
+
+import (
+	"sort"
+
+	"k8s.io/kubernetes/pkg/api"
+	client "k8s.io/kubernetes/pkg/client/unversioned"
+
+	"github.com/fabric8io/kansible/k8s"
+	"github.com/fabric8io/kansible/log"
+)
+
+const (
+	// ControllerAnnotation is used on the RC to indicate that hosts are assigned to its pods by the kansible
+	// controller for the named KansibleApp rather than each pod claiming its own host
+	ControllerAnnotation = "kansible.fabric8.io/controller"
+)
+
+// hostClaims are the claims on hosts by live pods from the annotations on the RC
+type hostClaims struct {
+	// entries are the claimed host entries with an entry for each claimed slot
+	entries []*HostEntry
+	// slots are the claimed slots indexed by host name
+	slots map[string]map[int]bool
+	// pods are the names of the pods which have claimed a host
+	pods map[string]bool
+	// stale are the annotation keys of claims by pods which are no longer live
+	stale map[string]string
+}
+
+// loadHostClaims loads the claims on hosts by live pods ignoring the claims by the given pod name
+func loadHostClaims(annotations map[string]string, pods *api.PodList, hostEntries []*HostEntry, ignorePodName string) *hostClaims {
+	claims := &hostClaims{
+		slots: map[string]map[int]bool{},
+		pods:  map[string]bool{},
+		stale: map[string]string{},
+	}
+	for annKey, podName := range annotations {
+		hostName, slot, ok := parseHostClaimAnnotation(annKey)
+		if ok {
+			if k8s.PodIsRunning(pods, podName) {
+				if podName != ignorePodName {
+					log.Info("Pod %s podName has already claimed host %s", podName, hostName)
+					claimedEntry := GetHostEntryByName(hostEntries, hostName)
+					if claimedEntry != nil {
+						claims.add(claimedEntry, slot, podName)
+					}
+				}
+			} else {
+				claims.stale[annKey] = podName
+			}
+		}
+	}
+	return claims
+}
+
+// add adds a claim by the given pod on the slot of the host
+func (claims *hostClaims) add(hostEntry *HostEntry, slot int, podName string) {
+	hostName := hostEntry.Name
+	if claims.slots[hostName] == nil {
+		claims.slots[hostName] = map[int]bool{}
+	}
+	claims.slots[hostName][slot] = true
+	claims.pods[podName] = true
+	claims.entries = append(claims.entries, hostEntry)
+}
+
+// available returns the host entries which have a slot which is not claimed
+func (claims *hostClaims) available(hostEntries []*HostEntry) []*HostEntry {
+	answer := []*HostEntry{}
+	for _, hostEntry := range hostEntries {
+		if len(claims.slots[hostEntry.Name]) < hostEntry.Slots() {
+			answer = append(answer, hostEntry)
+		}
+	}
+	return answer
+}
+
+// removeStaleHostClaims removes the annotations for the claims of pods which are no longer live
+// returning true if any annotations were removed
+func removeStaleHostClaims(c *client.Client, ns string, annotations map[string]string, claims *hostClaims) bool {
+	for annKey, podName := range claims.stale {
+		// lets remove this annotation as the pod is no longer valid
+		log.Info("Pod %s is no longer live so removing the annotation %s", podName, annKey)
+		delete(annotations, annKey)
+		releasePodHostLabels(c, ns, podName)
+	}
+	return len(claims.stale) > 0
+}
+
+// IsManagedByController returns true if the hosts are assigned to the pods of the RC by the kansible controller
+func IsManagedByController(rc *api.ReplicationController) bool {
+	annotations := rc.ObjectMeta.Annotations
+	return annotations != nil && len(annotations[ControllerAnnotation]) > 0
+}
+
+// assignedHostEntry returns the host entry assigned to the given pod or nil if it has not been assigned a host
+func assignedHostEntry(annotations map[string]string, hostEntries []*HostEntry, podName string) *HostEntry {
+	for annKey, value := range annotations {
+		if value != podName {
+			continue
+		}
+		hostName, slot, ok := parseHostClaimAnnotation(annKey)
+		if ok {
+			hostEntry := GetHostEntryByName(hostEntries, hostName)
+			if hostEntry != nil {
+				hostEntry.Slot = slot
+				return hostEntry
+			}
+		}
+	}
+	return nil
+}
+
+// AssignHosts assigns a host to each live pod of the RC which does not yet have one, spreading the pods
+// across the zones in the same way as pods claiming their own hosts. Claims by pods which are no longer
+// live are removed. Returns the number of pods which have a host, the number of pods still waiting for one
+// and whether the annotations on the RC were changed
+func AssignHosts(c *client.Client, ns string, rc *api.ReplicationController, pods *api.PodList, hostEntries []*HostEntry) (int, int, bool) {
+	metadata := &rc.ObjectMeta
+	if metadata.Annotations == nil {
+		metadata.Annotations = make(map[string]string)
+	}
+	annotations := metadata.Annotations
+	claims := loadHostClaims(annotations, pods, hostEntries, "")
+	changed := removeStaleHostClaims(c, ns, annotations, claims)
+
+	waiting := []string{}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		podName := pod.ObjectMeta.Name
+		if k8s.PodIsLive(pod) && !claims.pods[podName] {
+			waiting = append(waiting, podName)
+		}
+	}
+	sort.Strings(waiting)
+
+	for len(waiting) > 0 {
+		available := claims.available(hostEntries)
+		if len(available) == 0 {
+			break
+		}
+		podName := waiting[0]
+		waiting = waiting[1:]
+		pickedEntry := pickHostEntry(available, claims.entries)
+		slot := firstFreeSlot(claims.slots[pickedEntry.Name])
+		fields := pickedEntry.LogFields()
+		fields[log.FieldPod] = podName
+		fields[log.FieldNamespace] = ns
+		fields[log.FieldRC] = rc.ObjectMeta.Name
+		log.WithFields(fields).Info("Assigning host %s to pod %s", pickedEntry.Name, podName)
+		annotations[HostClaimAnnotation(pickedEntry.Name, slot)] = podName
+		claims.add(pickedEntry, slot, podName)
+		changed = true
+	}
+	return len(claims.pods), len(waiting), changed
+}
@@ -0,0 +1,220 @@
+/*
+ * Copyright 2016 Red Hat
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ansible
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/kubernetes/pkg/api"
+	client "k8s.io/kubernetes/pkg/client/unversioned"
+
+	"github.com/fabric8io/kansible/k8s"
+	"github.com/fabric8io/kansible/log"
+	"github.com/fabric8io/kansible/ssh"
+)
+
+const (
+	// KnownHostsKey is the key of the known_hosts file in the known hosts Secret
+	KnownHostsKey = "known_hosts"
+
+	// KnownHostsVolumeMount is the directory in the kansible pod where the known hosts Secret is mounted
+	KnownHostsVolumeMount = "/secrets/known-hosts"
+
+	knownHostsVolumeName = "known-hosts"
+	knownHostsRetries    = 5
+)
+
+// CollectKnownHosts returns the known_hosts lines of the SSH hosts and their jump hosts from the given known hosts
+// including any certificate authorities for them along with whether the host keys of all of them are known. If
+// scan is true the host keys of the hosts which are not known are scanned through their jump hosts
+func CollectKnownHosts(hostEntries []*HostEntry, knownHosts *ssh.KnownHosts, scan bool) ([]string, bool, error) {
+	answer := []string{}
+	found := map[string]bool{}
+	complete := true
+	add := func(lines []string) {
+		if len(lines) == 0 {
+			complete = false
+		}
+		for _, line := range lines {
+			if !found[line] {
+				found[line] = true
+				answer = append(answer, line)
+			}
+		}
+	}
+	for _, hostEntry := range hostEntries {
+		if hostEntry.Connection == ConnectionWinRM {
+			continue
+		}
+		jumpHosts, err := hostEntry.JumpHosts()
+		if err != nil {
+			return nil, false, err
+		}
+		for i, jumpHost := range jumpHosts {
+			lines, err := collectHostKnownHosts("jump host "+jumpHost.String(), jumpHost.Host, jumpHost.Port, jumpHosts[:i], knownHosts, scan)
+			if err != nil {
+				return nil, false, err
+			}
+			add(lines)
+		}
+		lines, err := collectHostKnownHosts("host "+hostEntry.Name, hostEntry.Host, hostEntry.Port, jumpHosts, knownHosts, scan)
+		if err != nil {
+			return nil, false, err
+		}
+		add(lines)
+	}
+	return answer, complete, nil
+}
+
+// collectHostKnownHosts returns the known_hosts lines of a host scanning its host key through the jump hosts if
+// its not known and scan is true. A scanned host key is trusted for the rest of the command so that the hosts
+// behind a scanned jump host can be scanned through it
+func collectHostKnownHosts(name string, host string, port string, jumpHosts []ssh.JumpHost, knownHosts *ssh.KnownHosts, scan bool) ([]string, error) {
+	lines := knownHosts.Lines(host, port)
+	if len(lines) > 0 {
+		return lines, nil
+	}
+	if !scan {
+		log.Warn("No host key is known for %s so its pods trust its host key on first use unless you use --scan-host-keys", name)
+		return lines, nil
+	}
+	key, err := ssh.ScanHostKey(host, port, jumpHosts)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("Scanned the host key %s of %s", ssh.Fingerprint(key), name)
+	line := ssh.KnownHostsLine(host, port, key)
+	err = ssh.TrustHostKey(line)
+	if err != nil {
+		return nil, err
+	}
+	return []string{line}, nil
+}
+
+// generateKnownHostsSecret creates or updates the Secret with the known hosts and mounts it in the pods of the RC.
+// Any lines already in the Secret for other hosts, such as host keys trusted on first use, are kept. The pods verify
+// the host keys strictly against the Secret; unless complete is false, as the host keys of some hosts are not known,
+// in which case they trust the host keys of those hosts on first use. Either way the mode in the RC YAML is kept
+func generateKnownHostsSecret(c *client.Client, ns string, knownHosts []string, complete bool, rc *api.ReplicationController) error {
+	if knownHosts == nil {
+		return nil
+	}
+	rcName := rc.ObjectMeta.Name
+	secretName := rcName + "-known-hosts"
+	labels := map[string]string{}
+	for k, v := range rc.ObjectMeta.Labels {
+		labels[k] = v
+	}
+	labels[RCLabel] = rcName
+
+	lines := []string{}
+	hosts := map[string]bool{}
+	for _, line := range knownHosts {
+		lines = append(lines, line)
+		hosts[knownHostsLineHosts(line)] = true
+	}
+
+	secretClient := c.Secrets(ns)
+	current, err := secretClient.Get(secretName)
+	if err != nil || current == nil {
+		current = nil
+	} else {
+		for _, line := range strings.Split(string(current.Data[KnownHostsKey]), "\n") {
+			line = strings.TrimSpace(line)
+			if len(line) > 0 && !hosts[knownHostsLineHosts(line)] {
+				lines = append(lines, line)
+			}
+		}
+	}
+	secret := &api.Secret{
+		ObjectMeta: api.ObjectMeta{
+			Name:   secretName,
+			Labels: labels,
+		},
+		Data: map[string][]byte{
+			KnownHostsKey: []byte(strings.Join(lines, "\n") + "\n"),
+		},
+	}
+	if current == nil {
+		_, err = secretClient.Create(secret)
+	} else {
+		secret.ObjectMeta.ResourceVersion = current.ObjectMeta.ResourceVersion
+		_, err = secretClient.Update(secret)
+	}
+	if err != nil {
+		return err
+	}
+
+	podSpec := k8s.GetOrCreatePodSpec(rc)
+	container := k8s.GetFirstContainerOrCreate(rc)
+	k8s.EnsurePodSpecHasSecretVolume(podSpec, knownHostsVolumeName, secretName)
+	k8s.EnsureContainerHasVolumeMount(container, knownHostsVolumeName, KnownHostsVolumeMount)
+	k8s.EnsureContainerHasEnvVar(container, EnvKnownHosts, KnownHostsVolumeMount+"/"+KnownHostsKey)
+	k8s.EnsureContainerHasEnvVar(container, EnvKnownHostsSecret, secretName)
+	if complete {
+		k8s.EnsureContainerHasEnvVar(container, EnvHostKeyChecking, ssh.HostKeyCheckingStrict)
+	} else {
+		k8s.EnsureContainerHasEnvVar(container, EnvHostKeyChecking, ssh.HostKeyCheckingTrustOnFirstUse)
+	}
+	return nil
+}
+
+// AddKnownHost adds the known_hosts line to the known hosts Secret so that host keys trusted on first use are
+// verified when the pods reconnect or are restarted
+func AddKnownHost(c *client.Client, ns string, secretName string, line string) error {
+	secretClient := c.Secrets(ns)
+	var err error
+	for i := 0; i < knownHostsRetries; i++ {
+		var secret *api.Secret
+		secret, err = secretClient.Get(secretName)
+		if err != nil {
+			return fmt.Errorf("Failed to load the known hosts Secret %s: %s", secretName, err)
+		}
+		if secret.Data == nil {
+			secret.Data = map[string][]byte{}
+		}
+		data := string(secret.Data[KnownHostsKey])
+		for _, existing := range strings.Split(data, "\n") {
+			if strings.TrimSpace(existing) == line {
+				return nil
+			}
+		}
+		if len(data) > 0 && !strings.HasSuffix(data, "\n") {
+			data += "\n"
+		}
+		secret.Data[KnownHostsKey] = []byte(data + line + "\n")
+		_, err = secretClient.Update(secret)
+		if err == nil {
+			return nil
+		}
+		log.Info("Failed to update the known hosts Secret %s, could be concurrent update failure: %s", secretName, err)
+	}
+	return fmt.Errorf("Failed to update the known hosts Secret %s: %s", secretName, err)
+}
+
+// knownHostsLineHosts returns the marker and host names of a known_hosts line
+func knownHostsLineHosts(line string) string {
+	fields := strings.Fields(line)
+	if len(fields) > 1 && strings.HasPrefix(fields[0], "@") {
+		return fields[0] + " " + fields[1]
+	}
+	if len(fields) > 0 {
+		return fields[0]
+	}
+	return ""
+}
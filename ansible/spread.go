@@ -0,0 +1,222 @@
+/*
+ * Copyright 2016 Red Hat
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ansible
+
+import (
+	"sort"
+	"strconv"
+
+	"k8s.io/kubernetes/pkg/api"
+	client "k8s.io/kubernetes/pkg/client/unversioned"
+
+	"github.com/fabric8io/kansible/k8s"
+	"github.com/fabric8io/kansible/log"
+)
+
+const (
+	// AnsibleVariableZone is the Ansible inventory host variable for the zone (e.g. data centre or rack) of a host
+	AnsibleVariableZone = "kansible_zone"
+
+	// AnsibleVariableWeight is the Ansible inventory host variable for the weight of a host.
+	// Hosts with a higher weight are claimed first and released last
+	AnsibleVariableWeight = "kansible_weight"
+
+	// DefaultHostWeight is the weight of a host which does not define the kansible_weight variable
+	DefaultHostWeight = 1
+
+	// maxHostWeight is the largest weight used when deciding which pods to remove when scaling down
+	maxHostWeight = 9999
+)
+
+// Zone returns the zone of the host or an empty string if it has none
+func (hostEntry *HostEntry) Zone() string {
+	return hostEntry.Variables[AnsibleVariableZone]
+}
+
+// Weight returns the weight of the host or DefaultHostWeight if it has none
+func (hostEntry *HostEntry) Weight() int {
+	text := hostEntry.Variables[AnsibleVariableWeight]
+	if len(text) == 0 {
+		return DefaultHostWeight
+	}
+	weight, err := strconv.Atoi(text)
+	if err != nil {
+		log.Warn("Ignoring invalid %s value `%s` on host %s: %s", AnsibleVariableWeight, text, hostEntry.Name, err)
+		return DefaultHostWeight
+	}
+	return weight
+}
+
+// pickHostEntry chooses which of the available hosts to claim. Hosts in the zone with the fewest claimed
+// hosts are preferred so that processes are spread across zones then hosts with the highest weight are
+// preferred; any remaining ties are broken randomly
+func pickHostEntry(available []*HostEntry, claimed []*HostEntry) *HostEntry {
+	if len(available) == 0 {
+		return nil
+	}
+	zoneCounts := map[string]int{}
+	for _, hostEntry := range claimed {
+		zoneCounts[hostEntry.Zone()]++
+	}
+
+	candidates := []*HostEntry{}
+	for _, hostEntry := range available {
+		if len(candidates) == 0 {
+			candidates = append(candidates, hostEntry)
+			continue
+		}
+		best := candidates[0]
+		c := compareHostPreference(hostEntry, best, zoneCounts)
+		if c > 0 {
+			candidates = []*HostEntry{hostEntry}
+		} else if c == 0 {
+			candidates = append(candidates, hostEntry)
+		}
+	}
+	return candidates[random(0, len(candidates))]
+}
+
+// compareHostPreference returns a positive number if host a should be claimed before host b,
+// a negative number if b should be claimed before a or zero if there is no preference
+func compareHostPreference(a *HostEntry, b *HostEntry, zoneCounts map[string]int) int {
+	za := zoneCounts[a.Zone()]
+	zb := zoneCounts[b.Zone()]
+	if za != zb {
+		return zb - za
+	}
+	return a.Weight() - b.Weight()
+}
+
+// claimedHostEntries returns the host entries which are claimed by the live pods in the annotations indexed by pod name
+func claimedHostEntries(annotations map[string]string, pods *api.PodList, hostEntries []*HostEntry) map[string]*HostEntry {
+	answer := map[string]*HostEntry{}
+	for annKey, podName := range annotations {
+		hostName, _, ok := parseHostClaimAnnotation(annKey)
+		if ok {
+			hostEntry := GetHostEntryByName(hostEntries, hostName)
+			if hostEntry != nil && k8s.PodIsRunning(pods, podName) {
+				answer[podName] = hostEntry
+			}
+		}
+	}
+	return answer
+}
+
+// podDeletionCosts calculates the deletion cost of each pod which has claimed a host so that
+// scaling down removes pods from the zones with the most pods first, then the pods on
+// the hosts with the lowest weight, so that the remaining pods stay spread across the zones
+func podDeletionCosts(claims map[string]*HostEntry) map[string]int {
+	zones := map[string][]string{}
+	for podName, hostEntry := range claims {
+		zone := hostEntry.Zone()
+		zones[zone] = append(zones[zone], podName)
+	}
+	costs := map[string]int{}
+	for _, podNames := range zones {
+		sort.Strings(podNames)
+		sort.Stable(byHostWeight{podNames, claims})
+		for rank, podName := range podNames {
+			weight := claims[podName].Weight()
+			if weight < 0 {
+				weight = 0
+			} else if weight > maxHostWeight {
+				weight = maxHostWeight
+			}
+			costs[podName] = weight - rank*(maxHostWeight+1)
+		}
+	}
+	return costs
+}
+
+// byHostWeight sorts the pod names by the weight of their claimed hosts with the highest weight first
+type byHostWeight struct {
+	podNames []string
+	claims   map[string]*HostEntry
+}
+
+func (b byHostWeight) Len() int {
+	return len(b.podNames)
+}
+
+func (b byHostWeight) Less(i, j int) bool {
+	return b.claims[b.podNames[i]].Weight() > b.claims[b.podNames[j]].Weight()
+}
+
+func (b byHostWeight) Swap(i, j int) {
+	b.podNames[i], b.podNames[j] = b.podNames[j], b.podNames[i]
+}
+
+// byDeletionCost sorts the pod names by their deletion cost with the lowest cost first
+type byDeletionCost struct {
+	podNames []string
+	costs    map[string]int
+}
+
+func (b byDeletionCost) Len() int {
+	return len(b.podNames)
+}
+
+func (b byDeletionCost) Less(i, j int) bool {
+	return b.costs[b.podNames[i]] < b.costs[b.podNames[j]]
+}
+
+func (b byDeletionCost) Swap(i, j int) {
+	b.podNames[i], b.podNames[j] = b.podNames[j], b.podNames[i]
+}
+
+// surplusPods returns the names of the live pods to remove so that only the given number of replicas remain.
+// Pods which have not claimed a host are removed first then the pods with the lowest deletion cost so that the
+// remaining pods stay spread across the zones
+func surplusPods(annotations map[string]string, pods *api.PodList, hostEntries []*HostEntry, replicas int) []string {
+	claims := claimedHostEntries(annotations, pods, hostEntries)
+	unclaimed := []string{}
+	claimed := []string{}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		podName := pod.ObjectMeta.Name
+		if !k8s.PodIsLive(pod) {
+			continue
+		}
+		if claims[podName] == nil {
+			unclaimed = append(unclaimed, podName)
+		} else {
+			claimed = append(claimed, podName)
+		}
+	}
+	count := len(unclaimed) + len(claimed) - replicas
+	if count <= 0 {
+		return []string{}
+	}
+	sort.Strings(unclaimed)
+	sort.Strings(claimed)
+	sort.Stable(byDeletionCost{claimed, podDeletionCosts(claims)})
+	return append(unclaimed, claimed...)[:count]
+}
+
+// deleteSurplusPods deletes the live pods above the given number of replicas which would not be removed in a way
+// which keeps the spread across the zones by the replication controller manager when it is scaled down. The pods
+// are deleted before the RC is scaled down; any replacement pods created in the meantime are not yet ready so
+// they are the ones removed by the replication controller manager when it is scaled down
+func deleteSurplusPods(c *client.Client, ns string, annotations map[string]string, pods *api.PodList, hostEntries []*HostEntry, replicas int) {
+	for _, podName := range surplusPods(annotations, pods, hostEntries, replicas) {
+		log.Info("Deleting pod %s to scale down to %d replicas", podName, replicas)
+		err := c.Pods(ns).Delete(podName, nil)
+		if err != nil {
+			log.Warn("Failed to delete pod %s: %s", podName, err)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+/*
+ * Copyright 2016 Red Hat
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ansible
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/kubernetes/pkg/api"
+)
+
+func TestSurplusPods(t *testing.T) {
+	hostEntries := []*HostEntry{
+		{Name: "app1", Variables: map[string]string{AnsibleVariableZone: "dc1", AnsibleVariableWeight: "10"}},
+		{Name: "app2", Variables: map[string]string{AnsibleVariableZone: "dc1"}},
+		{Name: "app3", Variables: map[string]string{AnsibleVariableZone: "dc1", AnsibleVariableWeight: "5"}},
+		{Name: "app4", Variables: map[string]string{AnsibleVariableZone: "dc2", AnsibleVariableWeight: "10"}},
+		{Name: "app5", Variables: map[string]string{AnsibleVariableZone: "dc2"}},
+	}
+	annotations := map[string]string{
+		HostClaimAnnotation("app1", 1): "pod1",
+		HostClaimAnnotation("app2", 1): "pod2",
+		HostClaimAnnotation("app3", 1): "pod3",
+		HostClaimAnnotation("app4", 1): "pod4",
+		HostClaimAnnotation("app5", 1): "pod5",
+	}
+	pods := &api.PodList{}
+	for _, podName := range []string{"pod1", "pod2", "pod3", "pod4", "pod5", "pod6"} {
+		pods.Items = append(pods.Items, api.Pod{
+			ObjectMeta: api.ObjectMeta{Name: podName},
+			Status:     api.PodStatus{Phase: api.PodRunning},
+		})
+	}
+	pods.Items = append(pods.Items, api.Pod{
+		ObjectMeta: api.ObjectMeta{Name: "pod7"},
+		Status:     api.PodStatus{Phase: api.PodFailed},
+	})
+
+	tests := []struct {
+		replicas int
+		expected []string
+	}{
+		{7, []string{}},
+		{6, []string{}},
+		{5, []string{"pod6"}},
+		{4, []string{"pod6", "pod2"}},
+		{3, []string{"pod6", "pod2", "pod5"}},
+		{2, []string{"pod6", "pod2", "pod5", "pod3"}},
+		{0, []string{"pod6", "pod2", "pod5", "pod3", "pod1", "pod4"}},
+	}
+	for _, test := range tests {
+		actual := surplusPods(annotations, pods, hostEntries, test.replicas)
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("Scaling down to %d replicas: expected to delete %v but got %v", test.replicas, test.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+/*
+ * Copyright 2016 Red Hat
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"os"
+
+	"k8s.io/kubernetes/pkg/api"
+	cmdutil "k8s.io/kubernetes/pkg/kubectl/cmd/util"
+
+	"github.com/fabric8io/kansible/ansible"
+	"github.com/fabric8io/kansible/k8s"
+	"github.com/fabric8io/kansible/log"
+	"github.com/fabric8io/kansible/ssh"
+)
+
+// claimedHost is the host claimed by this pod along with the RC which contains its inventory
+type claimedHost struct {
+	podName   string
+	claimName string
+	hostEntry *ansible.HostEntry
+	rc        *api.ReplicationController
+}
+
+// loadClaimedHost loads the host claimed by this pod from the pod annotations and the connection details of
+// the host from the inventory on the RC. Returns nil if this pod has not claimed a host
+func loadClaimedHost() *claimedHost {
+	f := cmdutil.NewFactory(clientConfig)
+	if f == nil {
+		log.Die("Failed to create Kubernetes client factory!")
+	}
+	kubeclient, err := f.Client()
+	if err != nil || kubeclient == nil {
+		log.Die(MessageFailedToCreateKubernetesClient, err)
+	}
+	ns := os.Getenv(ansible.EnvNamespace)
+	if len(ns) == 0 {
+		ns, _, _ = f.DefaultNamespace()
+		if len(ns) == 0 {
+			ns = "default"
+		}
+	}
+	thisPodName, err := k8s.GetThisPodName()
+	if err != nil {
+		log.Die("Failed to get this pod name: %s", err)
+	}
+
+	pod, err := kubeclient.Pods(ns).Get(thisPodName)
+	if err != nil {
+		log.Die("Failed to get pod from API server: %s", err)
+	}
+
+	annotations := pod.ObjectMeta.Annotations
+	if annotations == nil {
+		log.Die("No annotations available on pod %s", thisPodName)
+	}
+	hostName := annotations[ansible.HostNameAnnotation]
+	if len(hostName) == 0 {
+		log.Info("No annotation `%s` available on pod %s", ansible.HostNameAnnotation, thisPodName)
+		return nil
+	}
+
+	// now lets load the connection details from the RC annotations
+	rcName = os.ExpandEnv(rcName)
+	if rcName == "" {
+		log.Die("Replication controller name is required")
+	}
+	rc, err := kubeclient.ReplicationControllers(ns).Get(rcName)
+	if err != nil {
+		log.Die("Failed to get replication controller from API server: %s", err)
+	}
+	if rc == nil {
+		log.Die("No ReplicationController found for name %s", rcName)
+	}
+	metadata := &rc.ObjectMeta
+	if metadata.Annotations == nil {
+		metadata.Annotations = make(map[string]string)
+	}
+	rcAnnotations := metadata.Annotations
+
+	hostsText := rcAnnotations[ansible.HostInventoryAnnotation]
+	if len(hostsText) == 0 {
+		log.Die("Could not find annotation %s on ReplicationController %s", ansible.HostInventoryAnnotation, rcName)
+	}
+	hostEntries, err := ansible.LoadHostEntriesFromText(hostsText)
+	if err != nil {
+		log.Die("Failed to load hosts: %s", err)
+	}
+	log.Info("Found %d host entries", len(hostEntries))
+
+	hostEntry := ansible.GetHostEntryByName(hostEntries, hostName)
+	if hostEntry == nil {
+		log.Die("Could not find a HostEntry called `%s` from %d host entries", hostName, len(hostEntries))
+	}
+	jumpHosts, err := hostEntry.JumpHosts()
+	if err != nil {
+		log.Die("Failed to load the jump hosts: %s", err)
+	}
+	ssh.SetJumpHosts(hostEntry.Host, jumpHosts)
+	become, err := hostEntry.Become()
+	if err != nil {
+		log.Die("Failed to load the become settings: %s", err)
+	}
+	ssh.SetBecome(hostEntry.Host, become)
+	shell, err := hostEntry.Shell()
+	if err != nil {
+		log.Die("Failed to load the shell settings: %s", err)
+	}
+	ssh.SetShell(hostEntry.Host, shell)
+	return &claimedHost{
+		podName:   thisPodName,
+		claimName: ansible.PodClaimName(annotations),
+		hostEntry: hostEntry,
+		rc:        rc,
+	}
+}
@@ -0,0 +1,111 @@
+/*
+ * Copyright 2016 Red Hat
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/client/leaderelection"
+	"k8s.io/kubernetes/pkg/client/record"
+	cmdutil "k8s.io/kubernetes/pkg/kubectl/cmd/util"
+
+	"github.com/fabric8io/kansible/ansible"
+	"github.com/fabric8io/kansible/controller"
+	"github.com/fabric8io/kansible/k8s"
+	"github.com/fabric8io/kansible/log"
+)
+
+var (
+	resyncPeriod, leaseDuration time.Duration
+	leaderElect                 bool
+)
+
+func init() {
+	controllerCmd.Flags().DurationVar(&resyncPeriod, "resync", 30*time.Second, "how often all the KansibleApp resources are reconciled")
+	controllerCmd.Flags().BoolVar(&leaderElect, "leader-elect", true, "use leader election so that only one of the replicas of the controller is active")
+	controllerCmd.Flags().DurationVar(&leaseDuration, "lease-duration", 15*time.Second, "how long a non leader waits before trying to become the leader")
+
+	RootCmd.AddCommand(controllerCmd)
+}
+
+// controllerCmd runs the kansible controller which reconciles the KansibleApp resources
+var controllerCmd = &cobra.Command{
+	Use:   "controller",
+	Short: "Runs the kansible controller which reconciles the KansibleApp resources in a namespace",
+	Long:  `This commmand watches the KansibleApp resources in a namespace, creating their ReplicationControllers and assigning hosts to their pods. Any changes made to the ReplicationControllers by hand are reverted. Only ReplicationController templates are supported; Deployments are not.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		f := cmdutil.NewFactory(clientConfig)
+		if f == nil {
+			log.Die("Failed to create Kubernetes client factory!")
+		}
+		kubeclient, err := f.Client()
+		if err != nil || kubeclient == nil {
+			log.Die(MessageFailedToCreateKubernetesClient, err)
+		}
+		ns := os.Getenv(ansible.EnvNamespace)
+		if len(ns) == 0 {
+			ns, _, _ = f.DefaultNamespace()
+			if len(ns) == 0 {
+				ns = "default"
+			}
+		}
+
+		err = k8s.EnsureKansibleAppResourceExists(kubeclient)
+		if err != nil {
+			log.Die("Failed to register the KansibleApp resource: %s", err)
+		}
+
+		ctrl := controller.New(kubeclient, ns, resyncPeriod)
+		if !leaderElect {
+			ctrl.Run(make(chan struct{}))
+			return
+		}
+
+		identity, err := k8s.GetThisPodName()
+		if err != nil {
+			log.Die("Couldn't get pod name: %s", err)
+		}
+		broadcaster := record.NewBroadcaster()
+		broadcaster.StartRecordingToSink(kubeclient.Events(ns))
+		recorder := broadcaster.NewRecorder(api.EventSource{Component: "kansible-controller"})
+
+		leaderelection.RunOrDie(leaderelection.LeaderElectionConfig{
+			EndpointsMeta: api.ObjectMeta{
+				Namespace: ns,
+				Name:      "kansible-controller",
+			},
+			Identity:      identity,
+			Client:        kubeclient,
+			EventRecorder: recorder,
+			LeaseDuration: leaseDuration,
+			RenewDeadline: leaseDuration * 2 / 3,
+			RetryPeriod:   leaseDuration / 5,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: ctrl.Run,
+				OnStoppedLeading: func() {
+					log.Die("Lost the leadership of the kansible controller")
+				},
+				OnNewLeader: func(leader string) {
+					log.Info("The kansible controller leader is %s", leader)
+				},
+			},
+		})
+	},
+}
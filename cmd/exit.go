@@ -0,0 +1,73 @@
+/*
+ * Copyright 2016 Red Hat
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/fabric8io/kansible/log"
+)
+
+const (
+	// ExitCodeConnectionFailure is the exit code used if the remote command could not be run or its
+	// exit status is unknown; which is the same exit code used by the ssh client
+	ExitCodeConnectionFailure = 255
+
+	// DefaultTerminationMessagePath is the default path Kubernetes reads the termination message of a container from
+	DefaultTerminationMessagePath = "/dev/termination-log"
+)
+
+var (
+	terminationMessagePath string
+)
+
+func init() {
+	RootCmd.PersistentFlags().StringVar(&terminationMessagePath, "termination-log", DefaultTerminationMessagePath, "the file to write a summary of how the remote command completed to")
+}
+
+// exitStatus is implemented by the errors returned when a remote command completes with a non zero exit status
+type exitStatus interface {
+	ExitStatus() int
+}
+
+// exitWithRemoteStatus writes a summary of how the remote command completed to the termination message path
+// then exits with the exit status of the remote command or ExitCodeConnectionFailure if the command could not be run
+func exitWithRemoteStatus(hostName string, err error) {
+	code := 0
+	message := fmt.Sprintf("Remote command on host %s completed successfully", hostName)
+	if err != nil {
+		code = ExitCodeConnectionFailure
+		if status, ok := err.(exitStatus); ok {
+			code = status.ExitStatus()
+		}
+		message = fmt.Sprintf("Remote command on host %s failed with exit code %d: %s", hostName, code, err)
+	}
+	writeTerminationMessage(message)
+	os.Exit(code)
+}
+
+func writeTerminationMessage(message string) {
+	if len(terminationMessagePath) == 0 {
+		return
+	}
+	err := ioutil.WriteFile(terminationMessagePath, []byte(message+"\n"), 0644)
+	if err != nil {
+		log.Debug("Could not write the termination message to %s: %s", terminationMessagePath, err)
+	}
+}
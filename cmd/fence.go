@@ -0,0 +1,61 @@
+/*
+ * Copyright 2016 Red Hat
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"time"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/client/record"
+	client "k8s.io/kubernetes/pkg/client/unversioned"
+
+	"github.com/fabric8io/kansible/log"
+	"github.com/fabric8io/kansible/ssh"
+)
+
+const (
+	// EventReasonFenced is the reason of the Event recorded when a leftover process is stopped on a host
+	EventReasonFenced = "FencedLeftoverProcess"
+
+	// eventFlushPeriod is how long to wait for the Event to be recorded before continuing
+	eventFlushPeriod = 2 * time.Second
+)
+
+// fenceLeftoverProcess stops any process still running on the SSH host which was started by a previous pod
+// then records this pod as the owner of the host, reporting any stopped process as an Event on this pod
+func fenceLeftoverProcess(c *client.Client, ns string, thisPodName string, user string, privateKey string, password string, host string, port string, stateDir string, stop ssh.StopPolicy) error {
+	previousOwner, err := ssh.FenceRemoteProcess(user, privateKey, password, host, port, stateDir, thisPodName, stop)
+	if len(previousOwner) == 0 {
+		return err
+	}
+	message := "Stopped the leftover process on host " + host + " started by " + previousOwner
+	if err != nil {
+		message = "Failed to stop the leftover process on host " + host + " started by " + previousOwner + ": " + err.Error()
+	}
+	pod, podErr := c.Pods(ns).Get(thisPodName)
+	if podErr != nil {
+		log.Warn("Failed to get pod %s to record the Event: %s", thisPodName, podErr)
+		return err
+	}
+	broadcaster := record.NewBroadcaster()
+	watcher := broadcaster.StartRecordingToSink(c.Events(ns))
+	recorder := broadcaster.NewRecorder(api.EventSource{Component: "kansible"})
+	recorder.Eventf(pod, api.EventTypeWarning, EventReasonFenced, "%s", message)
+	time.Sleep(eventFlushPeriod)
+	watcher.Stop()
+	return err
+}
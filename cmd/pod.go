@@ -18,29 +18,35 @@ package cmd
 
 import (
 	"io/ioutil"
+	"net/http"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 	cmdutil "k8s.io/kubernetes/pkg/kubectl/cmd/util"
 
 	"github.com/fabric8io/kansible/ansible"
+	"github.com/fabric8io/kansible/health"
 	"github.com/fabric8io/kansible/k8s"
 	"github.com/fabric8io/kansible/log"
+	"github.com/fabric8io/kansible/logtail"
+	"github.com/fabric8io/kansible/metrics"
 	"github.com/fabric8io/kansible/ssh"
 	"github.com/fabric8io/kansible/winrm"
 )
 
 var (
-	rcName, passwordFlag, connection, bash string
+	rcName, passwordFlag, connection, bash, healthPort string
 )
 
 func init() {
 	podCmd.Flags().StringVar(&rcName, "rc", "$KANSIBLE_RC", "the name of the ReplicationController for the supervisors")
-	podCmd.Flags().StringVar(&passwordFlag, "password", "$KANSIBLE_PASSWORD", "the password used for WinRM connections")
+	podCmd.Flags().StringVar(&passwordFlag, "password", "$KANSIBLE_PASSWORD", "the password used for WinRM connections or SSH password authentication")
 	podCmd.Flags().StringVar(&connection, "connection", "", "the Ansible connection type to use. Defaults to SSH unless 'winrm' is defined to use WinRM on Windows")
 	podCmd.Flags().StringVar(&bash, "bash", "$KANSIBLE_BASH", "if specified a script is generated for running a bash like shell on the remote machine")
+	podCmd.Flags().StringVar(&healthPort, "health-port", "$KANSIBLE_HEALTH_PORT", "the port of the /healthz and /readyz endpoints; 0 disables them")
 
 	RootCmd.AddCommand(podCmd)
 }
@@ -87,11 +93,19 @@ var podCmd = &cobra.Command{
 			log.Die("Couldn't get pod name: %s", err)
 		}
 
+		isBashShell := os.Getenv(ansible.EnvIsBashShell) == "true"
+		if !isBashShell {
+			startHealthServer(os.ExpandEnv(healthPort))
+		}
+
 		hostEntry, rc, envVars, err := ansible.ChooseHostAndPrivateKey(thisPodName, hosts, kubeclient, ns, rcName)
 		if err != nil {
 
 			log.Die("Couldn't find host: %s", err)
 		}
+		if !isBashShell {
+			go ansible.MaintainReplicasPerHost(kubeclient, ns, rcName)
+		}
 		host := hostEntry.Host
 		user := hostEntry.User
 		port := hostEntry.Port
@@ -104,8 +118,12 @@ var podCmd = &cobra.Command{
 			connection = os.ExpandEnv(connection)
 		}
 
+		if isBashShell && len(command) == 0 {
+			command = interactiveShell(hostEntry)
+		}
+
 		runCommand := hostEntry.RunCommand
-		if len(runCommand) != 0 {
+		if len(runCommand) != 0 && !isBashShell {
 			command = runCommand
 		}
 
@@ -131,42 +149,211 @@ var podCmd = &cobra.Command{
 
 		bash := os.ExpandEnv(bash)
 		if len(bash) > 0 {
-			err = generateBashScript(bash, connection)
+			err = generateBashScript(bash)
 			if err != nil {
 				log.Err("Failed to generate bash script at %s due to: %v", bash, err)
 				return
 			}
 		}
 
+		password := hostEntry.Password
+		if len(password) == 0 {
+			password = os.ExpandEnv(passwordFlag)
+		}
+
 		if connection == ansible.ConnectionWinRM {
-			password := hostEntry.Password
-			if len(password) == 0 {
-				password = os.ExpandEnv(passwordFlag)
-				if password == "" {
-					log.Die("Cannot connect without a password")
+			if password == "" {
+				log.Die("Cannot connect without a password")
+			}
+			if !isBashShell {
+				startLogTailer(&winrm.LogFollower{User: user, Password: password, Host: host, Port: port})
+				processName := os.Getenv(ansible.EnvProcessName)
+				if len(processName) > 0 {
+					startProcessSampler(&winrm.ProcessSampler{User: user, Password: password, Host: host, Port: port, ProcessName: processName})
 				}
+				startProbeServer(probeSocket, &winrm.CommandRunner{User: user, Password: password, Host: host, Port: port})
 			}
-			err = winrm.RemoteWinRmCommand(user, password, host, port, command, kubeclient, rc, hostEntry.Name)
+			err = winrm.RemoteWinRmCommand(user, password, host, port, command, envVars, hostEntry.ShellType(), hostEntry.WorkingDir(), kubeclient, rc, hostEntry.ClaimName())
 		} else {
 			privatekey := hostEntry.PrivateKey
+			knownHostsSecret := os.Getenv(ansible.EnvKnownHostsSecret)
+			if len(knownHostsSecret) > 0 {
+				ssh.RecordNewHostKeys(func(line string) error {
+					return ansible.AddKnownHost(kubeclient, ns, knownHostsSecret, line)
+				})
+			}
 
-			err = ssh.RemoteSSHCommand(user, privatekey, host, port, command, envVars)
+			stop := stopPolicy()
+			stateName := sshStateName(rcName, hostEntry.ClaimName())
+			if !isBashShell {
+				err = fenceLeftoverProcess(kubeclient, ns, thisPodName, user, privatekey, password, host, port, ssh.StateDir(stateName), stop)
+				if err == nil {
+					startLogTailer(&ssh.LogFollower{User: user, PrivateKey: privatekey, Password: password, Host: host, Port: port})
+					startProcessSampler(&ssh.ProcessSampler{User: user, PrivateKey: privatekey, Password: password, Host: host, Port: port, StateDir: ssh.StateDir(stateName)})
+					startProbeServer(probeSocket, &ssh.CommandRunner{User: user, PrivateKey: privatekey, Password: password, Host: host, Port: port})
+					startReverseForwarder(&ssh.ReverseForwarder{User: user, PrivateKey: privatekey, Password: password, Host: host, Port: port})
+				}
+			}
+			if err != nil {
+				log.Err("Not running the command as a leftover process may still be running on host %s", host)
+			} else if isResilient() && !isBashShell {
+				supervisor := ssh.NewSupervisor(user, privatekey, password, host, port, command, envVars, stateName)
+				supervisor.Stop = stop
+				timeout := os.Getenv(ansible.EnvReconnectTimeout)
+				if len(timeout) > 0 {
+					supervisor.ReconnectTimeout, err = time.ParseDuration(timeout)
+					if err != nil {
+						log.Die("Invalid duration for $%s: %s", ansible.EnvReconnectTimeout, err)
+					}
+				}
+				err = supervisor.Run()
+			} else {
+				stateDir := ""
+				if !isBashShell {
+					stateDir = ssh.StateDir(stateName)
+				}
+				pty := hostEntry.Variables[ansible.KansibleVariablePty]
+				if len(pty) == 0 {
+					pty = os.Getenv(ansible.EnvPty)
+				}
+				err = ssh.RemoteSSHCommand(user, privatekey, password, host, port, command, envVars, stateDir, stop, usePty(pty, isBashShell))
+			}
 		}
 		if err != nil {
 			log.Err("Failed: %v", err)
 		}
+		exitWithRemoteStatus(hostEntry.Name, err)
 	},
 }
 
-func generateBashScript(file string, connection string) error {
-	shellCommand := "bash"
-	if connection == ansible.ConnectionWinRM {
-		shellCommand = "cmd"
+// startHealthServer serves the health and metrics endpoints on the given port or the default port if its blank
+func startHealthServer(port string) {
+	if len(port) == 0 {
+		port = strconv.Itoa(health.DefaultPort)
 	}
+	if port == "0" {
+		return
+	}
+	mux := http.NewServeMux()
+	health.Handle(mux)
+	metrics.Handle(mux)
+	address := ":" + port
+	log.Info("Serving the health and metrics endpoints on %s", address)
+	go func() {
+		err := http.ListenAndServe(address, mux)
+		if err != nil {
+			log.Err("Failed to serve the health and metrics endpoints on %s: %s", address, err)
+		}
+	}()
+}
+
+// startLogTailer starts tailing the log files on the host which match the glob patterns in the environment if there are any
+func startLogTailer(follower logtail.Follower) {
+	patterns := strings.Fields(os.Getenv(ansible.EnvLogFiles))
+	if len(patterns) == 0 {
+		return
+	}
+	prefix := strings.ToLower(os.Getenv(ansible.EnvLogFilePrefix)) != "false"
+	tailer := logtail.NewTailer(follower, patterns, prefix, logtail.Stdout)
+	go tailer.Run()
+}
+
+// startReverseForwarder starts forwarding the reverse ports in the environment from the host if there are any
+func startReverseForwarder(forwarder *ssh.ReverseForwarder) {
+	forwards, err := ssh.ParseReverseForwards(os.Getenv(ansible.EnvReversePorts))
+	if err != nil {
+		log.Die("Invalid $%s: %s", ansible.EnvReversePorts, err)
+	}
+	if len(forwards) == 0 {
+		return
+	}
+	forwarder.Forwards = forwards
+	go forwarder.Run()
+}
+
+// startProcessSampler starts sampling the resource usage of the remote process if a sample interval is specified
+func startProcessSampler(sampler metrics.ProcessSampler) {
+	intervalText := os.Getenv(ansible.EnvProcessSampleInterval)
+	if len(intervalText) == 0 {
+		return
+	}
+	interval, err := time.ParseDuration(intervalText)
+	if err != nil {
+		log.Die("Invalid duration for $%s: %s", ansible.EnvProcessSampleInterval, err)
+	}
+	go metrics.SampleProcess(sampler, interval)
+}
+
+// isResilient returns true if the remote command should be supervised in the resilient mode
+func isResilient() bool {
+	return strings.ToLower(os.Getenv(ansible.EnvResilient)) == "true"
+}
+
+// usePty returns whether a pseudo terminal is requested for the remote command from the given boolean value
+// or the default value if its blank
+func usePty(value string, defaultValue bool) bool {
+	if len(value) == 0 {
+		return defaultValue
+	}
+	answer, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Die("Invalid value `%s` for whether to use a pseudo terminal; expected true or false", value)
+	}
+	return answer
+}
+
+// sshStateName returns the name of the state directory on SSH hosts for the process of the pod which claimed the given host
+func sshStateName(rcName string, claimName string) string {
+	return rcName + "-" + claimName
+}
+
+// stopPolicy returns the policy for stopping the remote process on SSH hosts from the environment
+func stopPolicy() ssh.StopPolicy {
+	policy := ssh.DefaultStopPolicy()
+	signalName := os.Getenv(ansible.EnvStopSignal)
+	if len(signalName) > 0 {
+		name, err := ssh.ParseStopSignal(signalName)
+		if err != nil {
+			log.Die("Invalid signal for $%s: %s", ansible.EnvStopSignal, err)
+		}
+		policy.Signal = name
+	}
+	gracePeriod := os.Getenv(ansible.EnvStopGracePeriod)
+	if len(gracePeriod) > 0 {
+		duration, err := time.ParseDuration(gracePeriod)
+		if err != nil {
+			log.Die("Invalid duration for $%s: %s", ansible.EnvStopGracePeriod, err)
+		}
+		policy.GracePeriod = duration
+	}
+	return policy
+}
+
+// generateBashScript generates the script which opens an interactive shell on the host claimed by this pod.
+// The shell is chosen by the pod it runs as it depends on the operating system and shell settings of the host
+func generateBashScript(file string) error {
 	text := `#!/bin/sh
 echo "opening shell on remote machine..."
 export ` + ansible.EnvIsBashShell + `=true
 export ` + ansible.EnvPortForward + `=false
-kansible pod appservers ` + shellCommand + "\n"
+kansible pod appservers
+`
 	return ioutil.WriteFile(file, []byte(text), 0555)
 }
+
+// interactiveShell returns the command which opens an interactive shell on the host; cmd or PowerShell on windows
+// and otherwise the shell executable of the host or the login shell of the user
+func interactiveShell(hostEntry *ansible.HostEntry) string {
+	if hostEntry.Connection == ansible.ConnectionWinRM {
+		if hostEntry.ShellType() == ansible.ShellTypePowerShell {
+			return "powershell -NoLogo"
+		}
+		return "cmd"
+	}
+	executable := hostEntry.ShellExecutable()
+	if len(executable) > 0 {
+		// the command is run by the shell executable so its already in a POSIX shell
+		return "exec '" + strings.Replace(executable, "'", `'\''`, -1) + "' -i"
+	}
+	return `sh -c 'exec "${SHELL:-/bin/sh}" -i'`
+}
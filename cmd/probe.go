@@ -0,0 +1,198 @@
+/*
+ * Copyright 2016 Red Hat
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fabric8io/kansible/ansible"
+	"github.com/fabric8io/kansible/log"
+	"github.com/fabric8io/kansible/ssh"
+	"github.com/fabric8io/kansible/winrm"
+)
+
+const (
+	// DefaultProbeSocket is the default unix socket on which `kansible pod` runs probe commands over its connection
+	DefaultProbeSocket = "/tmp/kansible-probe.sock"
+
+	// ExitCodeProbeFailed is the exit code of `kansible probe` when the probe fails
+	ExitCodeProbeFailed = 1
+
+	probePath           = "/probe"
+	exitCodeHeader      = "X-Kansible-Exit-Code"
+	probeTimeoutPadding = 5 * time.Second
+)
+
+var (
+	probeTimeout time.Duration
+	probeSocket  string
+)
+
+func init() {
+	probeCmd.Flags().StringVar(&rcName, "rc", "$KANSIBLE_RC", "the name of the ReplicationController for the supervisors")
+	probeCmd.Flags().DurationVar(&probeTimeout, "timeout", 10*time.Second, "how long to wait for the remote command to complete")
+	probeCmd.Flags().StringVar(&probeSocket, "socket", DefaultProbeSocket, "the unix socket of the kansible pod used to run the command over its connection to the host")
+	podCmd.Flags().StringVar(&probeSocket, "probe-socket", DefaultProbeSocket, "the unix socket on which to run probe commands over the connection to the host; blank disables it")
+
+	RootCmd.AddCommand(probeCmd)
+}
+
+// commandRunner runs short lived commands on the claimed host
+type commandRunner interface {
+	Run(command string, timeout time.Duration) ([]byte, int, error)
+}
+
+// probeCmd runs a command on the host claimed by this pod for use as an exec probe
+var probeCmd = &cobra.Command{
+	Use:   "probe -- <command>",
+	Short: "Runs a command on the host claimed by this pod exiting with 0 if it succeeds or 1 if it fails",
+	Long: `This commmand runs a check on the remote host claimed by this pod for use as a liveness or readiness exec probe.
+
+The command is run over the connection of the kansible pod if its available; otherwise a new SSH or WinRM connection
+is made using the host details from the pod annotations and the Ansible inventory on the ReplicationController.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 1 {
+			log.Die("Expected arguments -- <command>")
+		}
+		command := strings.Join(args, " ")
+
+		output, exitCode, err := runProbeOverSocket(probeSocket, command, probeTimeout)
+		if err != nil {
+			log.Debug("Could not run the probe via the kansible pod so connecting to the host: %s", err)
+			runner := newClaimedHostRunner()
+			if runner == nil {
+				log.Err("This pod has not claimed a host yet")
+				os.Exit(ExitCodeProbeFailed)
+			}
+			output, exitCode, err = runner.Run(command, probeTimeout)
+		}
+		os.Stdout.Write(output)
+		if err != nil {
+			log.Err("Probe failed: %s", err)
+			os.Exit(ExitCodeProbeFailed)
+		}
+		if exitCode != 0 {
+			log.Err("Probe command `%s` failed with exit code %d", command, exitCode)
+			os.Exit(ExitCodeProbeFailed)
+		}
+	},
+}
+
+// newClaimedHostRunner creates a runner for the host claimed by this pod or returns nil if no host is claimed
+func newClaimedHostRunner() commandRunner {
+	claimed := loadClaimedHost()
+	if claimed == nil {
+		return nil
+	}
+	hostEntry := claimed.hostEntry
+	port := hostEntry.Port
+	password := hostEntry.Password
+	if len(password) == 0 {
+		password = os.Getenv("KANSIBLE_PASSWORD")
+	}
+	if hostEntry.Connection == ansible.ConnectionWinRM {
+		return &winrm.CommandRunner{User: hostEntry.User, Password: password, Host: hostEntry.Host, Port: port}
+	}
+	if len(port) == 0 {
+		port = strconv.Itoa(sshPort)
+	}
+	return &ssh.CommandRunner{User: hostEntry.User, PrivateKey: hostEntry.PrivateKey, Password: password, Host: hostEntry.Host, Port: port}
+}
+
+// startProbeServer runs probe commands sent to the unix socket using the given runner which reuses the connection to the host
+func startProbeServer(socket string, runner commandRunner) {
+	if len(socket) == 0 {
+		return
+	}
+	os.Remove(socket)
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		log.Warn("Failed to listen for probes on %s: %s", socket, err)
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(probePath, func(w http.ResponseWriter, r *http.Request) {
+		command := r.FormValue("command")
+		timeout, err := time.ParseDuration(r.FormValue("timeout"))
+		if err != nil || len(command) == 0 {
+			http.Error(w, "Expected a command and timeout", http.StatusBadRequest)
+			return
+		}
+		output, exitCode, err := runner.Run(command, timeout)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		w.Header().Set(exitCodeHeader, strconv.Itoa(exitCode))
+		w.Write(output)
+	})
+	go func() {
+		err := http.Serve(listener, mux)
+		if err != nil {
+			log.Warn("Failed to serve probes on %s: %s", socket, err)
+		}
+	}()
+}
+
+// runProbeOverSocket runs the command via the probe server of the kansible pod
+func runProbeOverSocket(socket string, command string, timeout time.Duration) ([]byte, int, error) {
+	if len(socket) == 0 {
+		return nil, 0, fmt.Errorf("No probe socket specified")
+	}
+	httpClient := &http.Client{
+		Timeout: timeout + probeTimeoutPadding,
+		Transport: &http.Transport{
+			Dial: func(network, address string) (net.Conn, error) {
+				return net.Dial("unix", socket)
+			},
+		},
+	}
+	resp, err := httpClient.PostForm("http://kansible"+probePath, url.Values{
+		"command": {command},
+		"timeout": {timeout.String()},
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp.StatusCode == http.StatusBadGateway {
+		// the command could not be run on the host so lets fail the probe rather than retry
+		return body, ExitCodeProbeFailed, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("Probe server returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	exitCode, err := strconv.Atoi(resp.Header.Get(exitCodeHeader))
+	if err != nil {
+		return nil, 0, fmt.Errorf("Invalid exit code from the probe server: %s", err)
+	}
+	return body, exitCode, nil
+}
@@ -23,6 +23,7 @@ import (
 
 	"github.com/fabric8io/kansible/ansible"
 	"github.com/fabric8io/kansible/log"
+	"github.com/fabric8io/kansible/ssh"
 	"github.com/spf13/cobra"
 )
 
@@ -32,13 +33,21 @@ const (
 )
 
 var (
-	inventory string
-	replicas  int
+	inventory     string
+	replicas      int
+	perHost       bool
+	clampReplicas bool
+	useController bool
+	scanHostKeys  bool
 )
 
 func init() {
 	rcCmd.Flags().StringVar(&inventory, "inventory", "inventory", "the location of your Ansible inventory file")
 	rcCmd.Flags().IntVar(&replicas, "replicas", -1, "specifies the number of replicas to create for the RC")
+	rcCmd.Flags().BoolVar(&perHost, "per-host", false, "run one replica per host (or per host slot) so that the replicas always track the capacity of the inventory")
+	rcCmd.Flags().BoolVar(&useController, "controller", false, "create or update a KansibleApp resource for the kansible controller to reconcile rather than the ReplicationController; only ReplicationController templates are supported, not Deployments")
+	rcCmd.Flags().BoolVar(&scanHostKeys, "scan-host-keys", false, "scan the host keys of the SSH hosts which are not in the known_hosts file")
+	rcCmd.Flags().BoolVar(&clampReplicas, "clamp-replicas", false, "reduce the replicas to the capacity of the inventory rather than failing if there are too many")
 
 	RootCmd.AddCommand(rcCmd)
 }
@@ -80,7 +89,32 @@ var rcCmd = &cobra.Command{
 
 		rcFile := "kubernetes/" + hosts + "/rc.yml"
 
-		_, err = ansible.UpdateKansibleRC(hostEntries, hosts, f, kubeclient, ns, rcFile, replicas)
+		knownHostsPath := os.ExpandEnv(knownHostsFile)
+		if len(knownHostsPath) == 0 {
+			knownHostsPath = ssh.DefaultKnownHostsFile()
+		}
+		localKnownHosts, err := ssh.LoadKnownHosts(knownHostsPath)
+		if err != nil {
+			log.Die("Cannot load the known hosts: %s", err)
+		}
+		knownHosts, knownHostsComplete, err := ansible.CollectKnownHosts(hostEntries, localKnownHosts, scanHostKeys)
+		if err != nil {
+			log.Die("Cannot collect the host keys: %s", err)
+		}
+
+		if perHost && replicas >= 0 {
+			log.Die("Cannot use both the --per-host and --replicas flags")
+		}
+
+		if useController {
+			_, err = ansible.UpdateKansibleApp(hostEntries, hosts, f, kubeclient, ns, rcFile, replicas, perHost, clampReplicas, knownHosts, knownHostsComplete)
+			if err != nil {
+				log.Die("Failed to update KansibleApp: %s", err)
+			}
+			return
+		}
+
+		_, err = ansible.UpdateKansibleRC(hostEntries, hosts, f, kubeclient, ns, rcFile, replicas, perHost, clampReplicas, knownHosts, knownHostsComplete)
 		if err != nil {
 			log.Die("Failed to update Kansible RC: %s", err)
 		}
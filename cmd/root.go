@@ -23,7 +23,9 @@ import (
 	"github.com/spf13/pflag"
 	"k8s.io/kubernetes/pkg/client/unversioned/clientcmd"
 
+	"github.com/fabric8io/kansible/ansible"
 	"github.com/fabric8io/kansible/log"
+	"github.com/fabric8io/kansible/ssh"
 )
 
 var (
@@ -41,8 +43,29 @@ of all your containers and processes along with common scaling, high availabilit
 
 More help is here: https://github.com/fabric8io/kansible/blob/master/README.md
 `,
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			err := log.Configure(os.ExpandEnv(logFormat), os.ExpandEnv(logLevel), os.ExpandEnv(logFile))
+			if err != nil {
+				log.Die("Invalid logging configuration: %s", err)
+			}
+			ssh.KeyPassphrase = os.Getenv(ansible.EnvSSHKeyPassphrase)
+			err = ssh.ConfigureHostKeyChecking(os.ExpandEnv(hostKeyChecking), os.ExpandEnv(knownHostsFile))
+			if err != nil {
+				log.Die("Invalid host key checking configuration: %s", err)
+			}
+			err = ssh.ConfigureKeepAlive(os.ExpandEnv(connectTimeout), os.ExpandEnv(keepAliveInterval), os.ExpandEnv(keepAliveCountMax))
+			if err != nil {
+				log.Die("Invalid SSH keepalive configuration: %s", err)
+			}
+		},
 	}
 
+	logFormat, logLevel, logFile string
+
+	hostKeyChecking, knownHostsFile string
+
+	connectTimeout, keepAliveInterval, keepAliveCountMax string
+
 	sshPort int
 
 	clientConfig clientcmd.ClientConfig
@@ -51,6 +74,14 @@ More help is here: https://github.com/fabric8io/kansible/blob/master/README.md
 func init() {
 	RootCmd.PersistentFlags().IntVar(&sshPort, "port", 22, "the port for the remote SSH connection")
 	RootCmd.PersistentFlags().BoolVar(&log.IsDebugging, "debug", false, "enable verbose debugging output")
+	RootCmd.PersistentFlags().StringVar(&logFormat, "log-format", "$KANSIBLE_LOG_FORMAT", "the format of the kansible log messages; either 'plain' or 'json'")
+	RootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "$KANSIBLE_LOG_LEVEL", "the minimum level of the kansible log messages; one of 'debug', 'info', 'warn' or 'error'")
+	RootCmd.PersistentFlags().StringVar(&logFile, "log-file", "$KANSIBLE_LOG_FILE", "the file the kansible log messages are written to instead of stderr")
+	RootCmd.PersistentFlags().StringVar(&hostKeyChecking, "host-key-checking", "$KANSIBLE_HOST_KEY_CHECKING", "how SSH host keys are verified; one of 'strict', 'tofu' (trust on first use) or 'off'; defaults to 'strict' if the known_hosts file exists otherwise 'tofu'")
+	RootCmd.PersistentFlags().StringVar(&knownHostsFile, "known-hosts", "$KANSIBLE_KNOWN_HOSTS", "the known_hosts file used to verify SSH host keys; defaults to ~/.ssh/known_hosts")
+	RootCmd.PersistentFlags().StringVar(&connectTimeout, "connect-timeout", "$KANSIBLE_SSH_CONNECT_TIMEOUT", "how long to wait for the connection and SSH handshake with a host; defaults to 30s")
+	RootCmd.PersistentFlags().StringVar(&keepAliveInterval, "keepalive-interval", "$KANSIBLE_SSH_KEEPALIVE_INTERVAL", "how often keepalives are sent over the SSH connections; defaults to 15s and 0 disables them")
+	RootCmd.PersistentFlags().StringVar(&keepAliveCountMax, "keepalive-count-max", "$KANSIBLE_SSH_KEEPALIVE_COUNT_MAX", "how many keepalives in a row can go unanswered before an SSH connection is closed; defaults to 3")
 
 	clientConfig = defaultClientConfig(RootCmd.PersistentFlags())
 }
@@ -36,6 +36,12 @@ import (
 
 var (
 	user, password, host, command, privatekey string
+
+	forwardAgent, runPty bool
+
+	jumpHost, jumpPrivateKey string
+
+	shellType, shellExecutable, workingDir string
 )
 
 func init() {
@@ -43,7 +49,14 @@ func init() {
 	runCmd.Flags().StringVar(&privatekey, "privatekey", "${KANSIBLE_PRIVATEKEY}", "the private key used for SSH")
 	runCmd.Flags().StringVar(&host, "host", "${KANSIBLE_HOST}", "the host for the remote connection")
 	runCmd.Flags().StringVar(&command, "command", "${KANSIBLE_COMMAND}", "the remote command to invoke on the host")
-	runCmd.Flags().StringVar(&password, "password", "", "the password if using WinRM to execute the command")
+	runCmd.Flags().StringVar(&password, "password", "", "the password if using WinRM or SSH password authentication")
+	runCmd.Flags().StringVar(&jumpHost, "jump-host", "", "the comma separated list of jump hosts in the [user@]host[:port] format through which to connect")
+	runCmd.Flags().StringVar(&jumpPrivateKey, "jump-privatekey", "", "the private key used for the jump hosts; defaults to the private key of the host")
+	runCmd.Flags().StringVar(&shellType, "shell-type", "${KANSIBLE_SHELL_TYPE}", "the type of the login shell of the user; sh, csh or fish for SSH or cmd or powershell for WinRM")
+	runCmd.Flags().StringVar(&shellExecutable, "shell-executable", "${KANSIBLE_SHELL_EXECUTABLE}", "the POSIX shell which runs the remote command on SSH hosts")
+	runCmd.Flags().StringVar(&workingDir, "working-dir", "${KANSIBLE_WORKING_DIR}", "the directory on the host in which the remote command is run")
+	runCmd.Flags().BoolVar(&runPty, "pty", false, "request a pseudo terminal for the remote command such as for an interactive shell")
+	runCmd.Flags().BoolVar(&forwardAgent, "forward-agent", false, "forward the ssh-agent of $SSH_AUTH_SOCK to the remote command")
 	runCmd.Flags().StringVar(&connection, "connection", "", "the Ansible connection type to use. Defaults to SSH unless 'winrm' is defined to use WinRM on Windows")
 
 	RootCmd.AddCommand(runCmd)
@@ -67,24 +80,45 @@ var runCmd = &cobra.Command{
 		if user == "" {
 			log.Die("User is required")
 		}
+		shellType = os.ExpandEnv(shellType)
+		shellExecutable = os.ExpandEnv(shellExecutable)
+		workingDir = os.ExpandEnv(workingDir)
+		var err error
 		if connection == ansible.ConnectionWinRM {
 			password = os.ExpandEnv(password)
 			if password == "" {
 				log.Die("Password is required")
 			}
-			err := winrm.RemoteWinRmCommand(user, password, host, strconv.Itoa(sshPort), command, nil, nil, "")
-			if err != nil {
-				log.Err("Failed: %v", err)
-			}
+			err = winrm.RemoteWinRmCommand(user, password, host, strconv.Itoa(sshPort), command, nil, shellType, workingDir, nil, nil, "")
 		} else {
 			privatekey = os.ExpandEnv(privatekey)
-			if privatekey == "" {
-				log.Die("Private key is required")
+			password = os.ExpandEnv(password)
+			ssh.AgentSocket = os.Getenv("SSH_AUTH_SOCK")
+			ssh.ForwardAgent = forwardAgent
+			if privatekey == "" && password == "" && ssh.AgentSocket == "" {
+				log.Die("Private key, password or an ssh-agent is required")
+			}
+			if len(jumpPrivateKey) == 0 {
+				jumpPrivateKey = privatekey
 			}
-			err := ssh.RemoteSSHCommand(user, privatekey, host, strconv.Itoa(sshPort), command, nil)
+			var jumpHosts []ssh.JumpHost
+			jumpHosts, err = ssh.ParseJumpHosts(jumpHost, user, jumpPrivateKey, password)
 			if err != nil {
-				log.Err("Failed: %v", err)
+				log.Die("Invalid jump hosts: %s", err)
 			}
+			ssh.SetJumpHosts(host, jumpHosts)
+			if len(shellType) > 0 || len(shellExecutable) > 0 || len(workingDir) > 0 {
+				shell, err := ssh.NewShell(shellType, shellExecutable, workingDir)
+				if err != nil {
+					log.Die("Invalid shell: %s", err)
+				}
+				ssh.SetShell(host, shell)
+			}
+			err = ssh.RemoteSSHCommand(user, privatekey, password, host, strconv.Itoa(sshPort), command, nil, "", stopPolicy(), runPty)
+		}
+		if err != nil {
+			log.Err("Failed: %v", err)
 		}
+		exitWithRemoteStatus(host, err)
 	},
 }
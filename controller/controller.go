@@ -0,0 +1,264 @@
+/*
+ * Copyright 2016 Red Hat
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controller
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"k8s.io/kubernetes/pkg/api"
+	client "k8s.io/kubernetes/pkg/client/unversioned"
+	"k8s.io/kubernetes/pkg/labels"
+	"k8s.io/kubernetes/pkg/watch"
+
+	"github.com/fabric8io/kansible/ansible"
+	"github.com/fabric8io/kansible/k8s"
+	"github.com/fabric8io/kansible/log"
+)
+
+// Controller reconciles the KansibleApp resources in a namespace by creating their ReplicationControllers,
+// assigning hosts to their pods, removing unused Secrets and reporting their status
+type Controller struct {
+	client *client.Client
+	ns     string
+	resync time.Duration
+}
+
+// watchRetryDelay is how long to wait before watching again after a watch fails or ends
+const watchRetryDelay = 5 * time.Second
+
+// New creates a new Controller for the given namespace which reconciles when the KansibleApp resources or their
+// ReplicationControllers change and every resync period
+func New(c *client.Client, ns string, resync time.Duration) *Controller {
+	return &Controller{
+		client: c,
+		ns:     ns,
+		resync: resync,
+	}
+}
+
+// Run reconciles all the KansibleApp resources whenever they or their ReplicationControllers change and every
+// resync period until the stop channel is closed
+func (ctrl *Controller) Run(stop <-chan struct{}) {
+	log.Info("Starting the kansible controller in namespace %s", ctrl.ns)
+	changes := make(chan struct{}, 1)
+	go ctrl.watchKansibleApps(changes, stop)
+	go ctrl.watchReplicationControllers(changes, stop)
+	for {
+		ctrl.reconcileAll()
+		select {
+		case <-stop:
+			log.Info("Stopping the kansible controller")
+			return
+		case <-changes:
+		case <-time.After(ctrl.resync):
+		}
+	}
+}
+
+// watchKansibleApps notifies the changes channel whenever a KansibleApp resource changes
+func (ctrl *Controller) watchKansibleApps(changes chan<- struct{}, stop <-chan struct{}) {
+	for {
+		events, err := k8s.WatchKansibleApps(ctrl.client, ctrl.ns, stop)
+		if err != nil {
+			log.Warn("Failed to watch the KansibleApp resources in namespace %s: %s", ctrl.ns, err)
+		} else {
+			for event := range events {
+				log.Debug("KansibleApp %s was %s", event.Object.ObjectMeta.Name, strings.ToLower(event.Type))
+				notify(changes)
+			}
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(watchRetryDelay):
+		}
+	}
+}
+
+// watchReplicationControllers notifies the changes channel whenever a ReplicationController of a KansibleApp changes
+func (ctrl *Controller) watchReplicationControllers(changes chan<- struct{}, stop <-chan struct{}) {
+	for {
+		watcher, err := ctrl.client.ReplicationControllers(ctrl.ns).Watch(api.ListOptions{})
+		if err != nil {
+			log.Warn("Failed to watch the ReplicationControllers in namespace %s: %s", ctrl.ns, err)
+		} else {
+			ctrl.consumeReplicationControllerEvents(watcher, changes, stop)
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(watchRetryDelay):
+		}
+	}
+}
+
+func (ctrl *Controller) consumeReplicationControllerEvents(watcher watch.Interface, changes chan<- struct{}, stop <-chan struct{}) {
+	defer watcher.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case event, ok := <-watcher.ResultChan():
+			if !ok {
+				return
+			}
+			rc, isRC := event.Object.(*api.ReplicationController)
+			if isRC && len(rc.ObjectMeta.Annotations[ansible.ControllerAnnotation]) > 0 {
+				log.Debug("ReplicationController %s was %s", rc.ObjectMeta.Name, strings.ToLower(string(event.Type)))
+				notify(changes)
+			}
+		}
+	}
+}
+
+// notify notifies the changes channel without blocking; if a notification is already pending the changes are
+// reconciled together
+func notify(changes chan<- struct{}) {
+	select {
+	case changes <- struct{}{}:
+	default:
+	}
+}
+
+func (ctrl *Controller) reconcileAll() {
+	apps, err := k8s.ListKansibleApps(ctrl.client, ctrl.ns)
+	if err != nil {
+		log.Err("Failed to list the KansibleApp resources in namespace %s: %s", ctrl.ns, err)
+		return
+	}
+	for i := range apps {
+		app := &apps[i]
+		status := app.Status
+		status.Conditions = append([]k8s.KansibleAppCondition{}, app.Status.Conditions...)
+		err = ctrl.reconcile(app)
+		if err != nil {
+			log.Err("Failed to reconcile KansibleApp %s: %s", app.ObjectMeta.Name, err)
+			app.Status.SetCondition(k8s.ConditionReconciled, api.ConditionFalse, "ReconcileFailed", err.Error())
+		} else {
+			app.Status.SetCondition(k8s.ConditionReconciled, api.ConditionTrue, "Reconciled", "")
+		}
+		if !api.Semantic.DeepEqual(status, app.Status) {
+			_, err = k8s.UpdateKansibleApp(ctrl.client, ctrl.ns, app)
+			if err != nil {
+				log.Warn("Failed to update the status of KansibleApp %s: %s", app.ObjectMeta.Name, err)
+			}
+		}
+	}
+}
+
+// reconcile creates or updates the RC for the given KansibleApp then assigns hosts to its pods
+func (ctrl *Controller) reconcile(app *k8s.KansibleApp) error {
+	c := ctrl.client
+	ns := ctrl.ns
+	name := app.ObjectMeta.Name
+	spec := app.Spec
+
+	err := k8s.CheckReplicationControllerKind(spec.Template.Kind)
+	if err != nil {
+		return fmt.Errorf("Invalid template in KansibleApp %s: %s", name, err)
+	}
+	hostEntries, err := ansible.LoadHostEntriesFromText(spec.Inventory)
+	if err != nil {
+		return err
+	}
+	if len(hostEntries) == 0 {
+		return fmt.Errorf("No host entries in the inventory of KansibleApp %s", name)
+	}
+
+	rcConfig := spec.Template
+	metadata := &rcConfig.ObjectMeta
+	if len(metadata.Name) == 0 {
+		metadata.Name = name
+	}
+	if metadata.Annotations == nil {
+		metadata.Annotations = make(map[string]string)
+	}
+	metadata.Annotations[ansible.ControllerAnnotation] = name
+	if len(spec.Command) > 0 {
+		k8s.SetContainerEnvVar(k8s.GetFirstContainerOrCreate(&rcConfig), ansible.EnvCommand, spec.Command)
+	}
+	err = ansible.ConfigureKansibleRC(&rcConfig, spec.Hosts, "KansibleApp "+name)
+	if err != nil {
+		return err
+	}
+
+	replicas := -1
+	if spec.Replicas != nil {
+		replicas = *spec.Replicas
+	}
+	rc, err := ansible.ApplyKansibleRC(c, ns, &rcConfig, hostEntries, replicas, spec.PerHost, spec.ClampReplicas)
+	if err != nil {
+		return err
+	}
+
+	pods, err := k8s.GetPodsForReplicationController(c, ns, rc)
+	if err != nil {
+		return err
+	}
+	assigned, waiting, changed := ansible.AssignHosts(c, ns, rc, pods, hostEntries)
+	if changed {
+		rc, err = c.ReplicationControllers(ns).Update(rc)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = ctrl.deleteUnusedSecrets(rc)
+	if err != nil {
+		log.Warn("Failed to remove unused Secrets for ReplicationController %s: %s", rc.ObjectMeta.Name, err)
+	}
+
+	status := &app.Status
+	status.Replicas = rc.Status.Replicas
+	status.Capacity = ansible.HostCapacity(hostEntries)
+	status.AssignedHosts = assigned
+	if waiting > 0 {
+		status.SetCondition(k8s.ConditionHostsAssigned, api.ConditionFalse, "NoHostsAvailable", fmt.Sprintf("%d pods are waiting for a host", waiting))
+	} else {
+		status.SetCondition(k8s.ConditionHostsAssigned, api.ConditionTrue, "HostsAssigned", "")
+	}
+	return nil
+}
+
+// deleteUnusedSecrets deletes the Secrets generated for the RC which are no longer used by its pod template
+func (ctrl *Controller) deleteUnusedSecrets(rc *api.ReplicationController) error {
+	selector := labels.SelectorFromSet(labels.Set{ansible.RCLabel: rc.ObjectMeta.Name})
+	secretClient := ctrl.client.Secrets(ctrl.ns)
+	secrets, err := secretClient.List(api.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return err
+	}
+	used := map[string]bool{}
+	for _, volume := range k8s.GetOrCreatePodSpec(rc).Volumes {
+		if volume.Secret != nil {
+			used[volume.Secret.SecretName] = true
+		}
+	}
+	for _, secret := range secrets.Items {
+		secretName := secret.ObjectMeta.Name
+		if !used[secretName] {
+			log.Info("Deleting Secret %s as it is no longer used by ReplicationController %s", secretName, rc.ObjectMeta.Name)
+			err = secretClient.Delete(secretName)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
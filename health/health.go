@@ -0,0 +1,165 @@
+/*
+ * Copyright 2016 Red Hat
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package health
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fabric8io/kansible/metrics"
+)
+
+const (
+	// DefaultPort is the default port of the health endpoints of a kansible pod
+	DefaultPort = 9189
+
+	// HealthzPath is the path of the liveness endpoint
+	HealthzPath = "/healthz"
+
+	// ReadyzPath is the path of the readiness endpoint
+	ReadyzPath = "/readyz"
+
+	portCheckTimeout = 2 * time.Second
+)
+
+// status is the health of the connection to the host and the remote process
+type status struct {
+	lock      sync.Mutex
+	started   bool
+	connected bool
+	alive     bool
+	ports     map[string]string
+}
+
+var current = &status{
+	ports: map[string]string{},
+}
+
+// dialPort opens a connection to a forwarded port on the host
+var dialPort = func(address string) (net.Conn, error) {
+	return net.DialTimeout("tcp", address, portCheckTimeout)
+}
+
+// SetPortDialer sets the function used to check the forwarded ports accept connections such as one which
+// tunnels through the jump hosts of the host
+func SetPortDialer(dial func(address string) (net.Conn, error)) {
+	current.lock.Lock()
+	defer current.lock.Unlock()
+	dialPort = dial
+}
+
+// SetConnected records whether the SSH or WinRM session to the host is connected
+func SetConnected(connected bool) {
+	current.lock.Lock()
+	defer current.lock.Unlock()
+	current.connected = connected
+	metrics.SetConnected(connected)
+}
+
+// SetProcessAlive records whether the remote process is running
+func SetProcessAlive(alive bool) {
+	current.lock.Lock()
+	defer current.lock.Unlock()
+	current.alive = alive
+	metrics.SetProcessUp(alive)
+	if alive {
+		current.started = true
+	}
+}
+
+// AddForwardedPort records a port forwarded to the given address on the host which should accept connections
+// for the pod to be ready
+func AddForwardedPort(name string, address string) {
+	current.lock.Lock()
+	defer current.lock.Unlock()
+	if len(name) == 0 {
+		name = address
+	}
+	current.ports[name] = address
+}
+
+// check returns the problems with the health of the pod. If ready is false only the problems which mean
+// the pod should be restarted are returned; which is only when the remote process is known to have stopped.
+// A lost session is only a readiness problem as the supervisor reconnects to the host and the pod exits by
+// itself if reconnecting gives up; before the remote process has started the pod is considered live
+func check(ready bool) []string {
+	current.lock.Lock()
+	started := current.started
+	connected := current.connected
+	alive := current.alive
+	ports := map[string]string{}
+	for name, address := range current.ports {
+		ports[name] = address
+	}
+	dial := dialPort
+	current.lock.Unlock()
+
+	problems := []string{}
+	if !started {
+		if ready {
+			problems = append(problems, "the remote process has not started yet")
+		}
+		return problems
+	}
+	if !alive {
+		problems = append(problems, "the remote process is not running")
+	}
+	if ready {
+		if !connected {
+			problems = append(problems, "the session to the host is not connected")
+		}
+		names := []string{}
+		for name := range ports {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			conn, err := dial(ports[name])
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("the forwarded port %s does not accept connections: %s", name, err))
+			} else {
+				conn.Close()
+			}
+		}
+	}
+	return problems
+}
+
+// Handle registers the health endpoints on the given mux
+func Handle(mux *http.ServeMux) {
+	mux.HandleFunc(HealthzPath, func(w http.ResponseWriter, r *http.Request) {
+		writeProblems(w, check(false))
+	})
+	mux.HandleFunc(ReadyzPath, func(w http.ResponseWriter, r *http.Request) {
+		writeProblems(w, check(true))
+	})
+}
+
+func writeProblems(w http.ResponseWriter, problems []string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if len(problems) > 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, strings.Join(problems, "\n"))
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
@@ -0,0 +1,66 @@
+/*
+ * Copyright 2016 Red Hat
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package health
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name      string
+		started   bool
+		connected bool
+		alive     bool
+		portOpen  bool
+		live      bool
+		ready     bool
+	}{
+		{name: "waiting for a host", live: true},
+		{name: "running", started: true, connected: true, alive: true, portOpen: true, live: true, ready: true},
+		{name: "disconnected while running", started: true, alive: true, portOpen: true, live: true},
+		{name: "port not accepting connections", started: true, connected: true, alive: true, live: true},
+		{name: "process stopped", started: true, connected: true, portOpen: true},
+		{name: "process stopped while disconnected", started: true, portOpen: true},
+	}
+	defer SetPortDialer(dialPort)
+	for _, test := range tests {
+		portOpen := test.portOpen
+		current = &status{
+			started:   test.started,
+			connected: test.connected,
+			alive:     test.alive,
+			ports:     map[string]string{"http": "127.0.0.1:8080"},
+		}
+		SetPortDialer(func(address string) (net.Conn, error) {
+			if !portOpen {
+				return nil, errors.New("connection refused")
+			}
+			client, server := net.Pipe()
+			server.Close()
+			return client, nil
+		})
+		if live := len(check(false)) == 0; live != test.live {
+			t.Errorf("%s: expected live to be %v but got the problems %q", test.name, test.live, check(false))
+		}
+		if ready := len(check(true)) == 0; ready != test.ready {
+			t.Errorf("%s: expected ready to be %v but got the problems %q", test.name, test.ready, check(true))
+		}
+	}
+}
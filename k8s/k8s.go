@@ -21,13 +21,17 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"strings"
+	"time"
 
 	"k8s.io/kubernetes/pkg/api"
 	client "k8s.io/kubernetes/pkg/client/unversioned"
 	"k8s.io/kubernetes/pkg/kubectl"
 	cmdutil "k8s.io/kubernetes/pkg/kubectl/cmd/util"
 	"k8s.io/kubernetes/pkg/kubectl/resource"
+	"k8s.io/kubernetes/pkg/labels"
 	"k8s.io/kubernetes/pkg/runtime"
+	"k8s.io/kubernetes/pkg/util/intstr"
 	"k8s.io/kubernetes/pkg/util/strategicpatch"
 
 	"github.com/ghodss/yaml"
@@ -35,6 +39,14 @@ import (
 	"github.com/fabric8io/kansible/log"
 )
 
+const (
+	// ReplicationControllerKind is the kind of the ReplicationController templates used for the kansible pods
+	ReplicationControllerKind = "ReplicationController"
+
+	// UnreadyPodTimeout is how long a pod without any running containers can be unready before it is no longer live
+	UnreadyPodTimeout = 5 * time.Minute
+)
+
 // GetThisPodName returns this pod name via the `HOSTNAME` environment variable
 func GetThisPodName() (string, error) {
 	var err error
@@ -66,19 +78,96 @@ func ReadReplicationController(data []byte) (*api.ReplicationController, error)
 	if err := yaml.Unmarshal(data, &rc); err != nil {
 		return nil, err
 	}
+	if err := CheckReplicationControllerKind(rc.Kind); err != nil {
+		return nil, err
+	}
 	return &rc, nil
 }
 
-// PodIsRunning returns true if the given pod is running in the given list of all pods
+// CheckReplicationControllerKind returns an error if the kind of a kansible template is not a ReplicationController;
+// a blank kind is assumed to be a ReplicationController
+func CheckReplicationControllerKind(kind string) error {
+	if len(kind) > 0 && kind != ReplicationControllerKind {
+		return fmt.Errorf("Unsupported kind %s; kansible only supports a ReplicationController template", kind)
+	}
+	return nil
+}
+
+// PodIsRunning returns true if the given pod is in the given list of pods and is still live
+// so that any host it has claimed should not be given to another pod
 func PodIsRunning(pods *api.PodList, podName string) bool {
-	for _, pod := range pods.Items {
+	for i := range pods.Items {
+		pod := &pods.Items[i]
 		if pod.ObjectMeta.Name == podName {
-			return true
+			return PodIsLive(pod)
 		}
 	}
 	return false
 }
 
+// PodIsLive returns true if the pod is pending or running and is not being deleted. A pod whose containers are
+// waiting to be restarted, such as in CrashLoopBackOff, is still live as it keeps its host when it comes back;
+// unless it has not been ready for UnreadyPodTimeout and none of its containers are running, so that a pod which
+// keeps crashing does not hold on to its host forever. Pods which have failed, succeeded or are being deleted
+// release their host claims straight away
+func PodIsLive(pod *api.Pod) bool {
+	if pod == nil || pod.ObjectMeta.DeletionTimestamp != nil {
+		return false
+	}
+	switch pod.Status.Phase {
+	case api.PodSucceeded, api.PodFailed:
+		return false
+	}
+	return !podIsStuck(pod, time.Now())
+}
+
+// podIsStuck returns true if the pod has been unready for longer than UnreadyPodTimeout and its containers have
+// all terminated rather than running or starting for the first time
+func podIsStuck(pod *api.Pod, now time.Time) bool {
+	unreadySince := time.Time{}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == api.PodReady && condition.Status != api.ConditionTrue {
+			unreadySince = condition.LastTransitionTime.Time
+		}
+	}
+	if unreadySince.IsZero() || now.Sub(unreadySince) < UnreadyPodTimeout || len(pod.Status.ContainerStatuses) == 0 {
+		return false
+	}
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Running != nil {
+			return false
+		}
+		if status.State.Terminated == nil && status.LastTerminationState.Terminated == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// GetPodsForReplicationController returns the pods matching the selector of the given ReplicationController
+func GetPodsForReplicationController(c *client.Client, ns string, rc *api.ReplicationController) (*api.PodList, error) {
+	options := api.ListOptions{}
+	if rc != nil && len(rc.Spec.Selector) > 0 {
+		options.LabelSelector = labels.SelectorFromSet(labels.Set(rc.Spec.Selector))
+	}
+	return c.Pods(ns).List(options)
+}
+
+// ToLabelValue converts the given text into a valid label value by replacing any invalid characters
+// and truncating it to the maximum label value length
+func ToLabelValue(text string) string {
+	buffer := []byte(text)
+	for i, ch := range buffer {
+		if !((ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || (ch >= '0' && ch <= '9') || ch == '-' || ch == '_' || ch == '.') {
+			buffer[i] = '-'
+		}
+	}
+	if len(buffer) > 63 {
+		buffer = buffer[0:63]
+	}
+	return strings.Trim(string(buffer), "-_.")
+}
+
 // GetFirstContainerOrCreate returns the first Container in the PodSpec for this ReplicationController
 // lazily creating structures as required
 func GetFirstContainerOrCreate(rc *api.ReplicationController) *api.Container {
@@ -122,6 +211,19 @@ func GetContainerEnvVar(container *api.Container, name string) string {
 	return ""
 }
 
+// IsEnvVarFromSecret returns true if the environment variable of any container of the pod is populated from a
+// Secret via `valueFrom` so that its value can be masked
+func IsEnvVarFromSecret(pod *api.Pod, name string) bool {
+	for _, container := range pod.Spec.Containers {
+		for _, env := range container.Env {
+			if env.Name == name && env.ValueFrom != nil && env.ValueFrom.SecretKeyRef != nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // EnsureContainerHasEnvVar if there is an existing EnvVar for the given name then lets update it
 // with the given value otherwise lets add a new entry.
 // Returns true if there was already an existing environment variable
@@ -139,6 +241,23 @@ func EnsureContainerHasEnvVar(container *api.Container, name string, value strin
 	return false
 }
 
+// SetContainerEnvVar sets the EnvVar for the given name to the given value replacing any existing value
+// or value source
+func SetContainerEnvVar(container *api.Container, name string, value string) {
+	for i := range container.Env {
+		env := &container.Env[i]
+		if env.Name == name {
+			env.Value = value
+			env.ValueFrom = nil
+			return
+		}
+	}
+	container.Env = append(container.Env, api.EnvVar{
+		Name:  name,
+		Value: value,
+	})
+}
+
 // EnsureContainerHasEnvVarFromField if there is an existing EnvVar for the given name then lets update it
 // with the given fieldPath otherwise lets add a new entry.
 // Returns true if there was already an existing environment variable
@@ -178,6 +297,38 @@ func EnsureContainerHasPreStopCommand(container *api.Container, commands []strin
 	}
 }
 
+// EnsureContainerHasHTTPProbes ensures that the given container has a liveness and readiness probe; any
+// probes which are not defined use a HTTP GET of the given paths on the port
+func EnsureContainerHasHTTPProbes(container *api.Container, port int, livenessPath string, readinessPath string) {
+	if container.LivenessProbe == nil {
+		container.LivenessProbe = &api.Probe{
+			Handler: api.Handler{
+				HTTPGet: &api.HTTPGetAction{
+					Path: livenessPath,
+					Port: intstr.FromInt(port),
+				},
+			},
+			InitialDelaySeconds: 60,
+			TimeoutSeconds:      5,
+			PeriodSeconds:       10,
+			FailureThreshold:    3,
+		}
+	}
+	if container.ReadinessProbe == nil {
+		container.ReadinessProbe = &api.Probe{
+			Handler: api.Handler{
+				HTTPGet: &api.HTTPGetAction{
+					Path: readinessPath,
+					Port: intstr.FromInt(port),
+				},
+			},
+			InitialDelaySeconds: 5,
+			TimeoutSeconds:      5,
+			PeriodSeconds:       10,
+		}
+	}
+}
+
 // EnsureContainerHasVolumeMount ensures that there is a volume mount of the given name with the given values
 // Returns true if there was already a volume mount
 func EnsureContainerHasVolumeMount(container *api.Container, name string, mountPath string) bool {
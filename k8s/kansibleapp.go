@@ -0,0 +1,263 @@
+/*
+ * Copyright 2016 Red Hat
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package k8s
+
+import (
+	"encoding/json"
+	"io"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/unversioned"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	"k8s.io/kubernetes/pkg/client/restclient"
+	client "k8s.io/kubernetes/pkg/client/unversioned"
+
+	"github.com/fabric8io/kansible/log"
+)
+
+const (
+	// KansibleAppKind is the kind of the KansibleApp custom resource
+	KansibleAppKind = "KansibleApp"
+
+	// KansibleAppGroup is the API group of the KansibleApp custom resource
+	KansibleAppGroup = "fabric8.io"
+
+	// KansibleAppVersion is the API version of the KansibleApp custom resource
+	KansibleAppVersion = "v1"
+
+	// KansibleAppResource is the REST resource name of the KansibleApp custom resource
+	KansibleAppResource = "kansibleapps"
+
+	// KansibleAppResourceName is the name of the ThirdPartyResource which defines the KansibleApp custom resource
+	KansibleAppResourceName = "kansible-app." + KansibleAppGroup
+
+	// ConditionReconciled is the KansibleApp condition for whether the last reconcile succeeded
+	ConditionReconciled = "Reconciled"
+
+	// ConditionHostsAssigned is the KansibleApp condition for whether every pod has been assigned a host
+	ConditionHostsAssigned = "HostsAssigned"
+)
+
+// KansibleApp is the custom resource which describes the hosts, command and ReplicationController template
+// of a kansible application which is reconciled by the kansible controller
+type KansibleApp struct {
+	unversioned.TypeMeta `json:",inline"`
+	api.ObjectMeta       `json:"metadata,omitempty"`
+
+	Spec   KansibleAppSpec   `json:"spec"`
+	Status KansibleAppStatus `json:"status,omitempty"`
+}
+
+// KansibleAppSpec is the desired state of a KansibleApp
+type KansibleAppSpec struct {
+	// Hosts is the name of the hosts group in the Ansible inventory
+	Hosts string `json:"hosts"`
+
+	// Inventory is the Ansible inventory text for the hosts
+	Inventory string `json:"inventory"`
+
+	// Command overrides the command in the template if specified
+	Command string `json:"command,omitempty"`
+
+	// Replicas is the number of replicas or nil to keep the current replicas
+	Replicas *int `json:"replicas,omitempty"`
+
+	// PerHost is true if the replicas track the capacity of the inventory
+	PerHost bool `json:"perHost,omitempty"`
+
+	// ClampReplicas is true if replicas above the capacity of the inventory are reduced to the capacity rather
+	// than failing
+	ClampReplicas bool `json:"clampReplicas,omitempty"`
+
+	// Template is the ReplicationController to create for the kansible pods
+	Template api.ReplicationController `json:"template"`
+}
+
+// KansibleAppStatus is the observed state of a KansibleApp
+type KansibleAppStatus struct {
+	Replicas      int                    `json:"replicas"`
+	Capacity      int                    `json:"capacity"`
+	AssignedHosts int                    `json:"assignedHosts"`
+	Conditions    []KansibleAppCondition `json:"conditions,omitempty"`
+}
+
+// KansibleAppEvent is a change to a KansibleApp resource reported by WatchKansibleApps
+type KansibleAppEvent struct {
+	Type   string      `json:"type"`
+	Object KansibleApp `json:"object"`
+}
+
+// KansibleAppCondition describes the state of a KansibleApp at a certain point
+type KansibleAppCondition struct {
+	Type               string              `json:"type"`
+	Status             api.ConditionStatus `json:"status"`
+	LastTransitionTime unversioned.Time    `json:"lastTransitionTime,omitempty"`
+	Reason             string              `json:"reason,omitempty"`
+	Message            string              `json:"message,omitempty"`
+}
+
+// KansibleAppList is a list of KansibleApp resources
+type KansibleAppList struct {
+	unversioned.TypeMeta `json:",inline"`
+	unversioned.ListMeta `json:"metadata,omitempty"`
+
+	Items []KansibleApp `json:"items"`
+}
+
+// SetCondition adds or updates the condition of the given type only changing the transition time if the status changes
+func (status *KansibleAppStatus) SetCondition(conditionType string, conditionStatus api.ConditionStatus, reason string, message string) {
+	for i := range status.Conditions {
+		condition := &status.Conditions[i]
+		if condition.Type == conditionType {
+			if condition.Status != conditionStatus {
+				condition.LastTransitionTime = unversioned.Now()
+			}
+			condition.Status = conditionStatus
+			condition.Reason = reason
+			condition.Message = message
+			return
+		}
+	}
+	status.Conditions = append(status.Conditions, KansibleAppCondition{
+		Type:               conditionType,
+		Status:             conditionStatus,
+		LastTransitionTime: unversioned.Now(),
+		Reason:             reason,
+		Message:            message,
+	})
+}
+
+// EnsureKansibleAppResourceExists ensures that the ThirdPartyResource for KansibleApp is registered
+func EnsureKansibleAppResourceExists(c *client.Client) error {
+	tprClient := c.Extensions().ThirdPartyResources("")
+	tpr, err := tprClient.Get(KansibleAppResourceName)
+	if err == nil && tpr != nil {
+		return nil
+	}
+	log.Info("Creating ThirdPartyResource %s", KansibleAppResourceName)
+	_, err = tprClient.Create(&extensions.ThirdPartyResource{
+		ObjectMeta: api.ObjectMeta{
+			Name: KansibleAppResourceName,
+		},
+		Description: "A kansible application which runs processes on the hosts of an Ansible inventory",
+		Versions: []extensions.APIVersion{
+			{Name: KansibleAppVersion},
+		},
+	})
+	return err
+}
+
+// ListKansibleApps returns all the KansibleApp resources in the given namespace
+func ListKansibleApps(c *client.Client, ns string) ([]KansibleApp, error) {
+	data, err := c.RESTClient.Get().AbsPath(kansibleAppPath(ns)...).DoRaw()
+	if err != nil {
+		return nil, err
+	}
+	list := KansibleAppList{}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+// GetKansibleApp returns the KansibleApp resource for the given name
+func GetKansibleApp(c *client.Client, ns string, name string) (*KansibleApp, error) {
+	data, err := c.RESTClient.Get().AbsPath(kansibleAppPath(ns, name)...).DoRaw()
+	if err != nil {
+		return nil, err
+	}
+	app := &KansibleApp{}
+	if err := json.Unmarshal(data, app); err != nil {
+		return nil, err
+	}
+	return app, nil
+}
+
+// WatchKansibleApps watches the KansibleApp resources in the given namespace. The returned channel is closed when
+// the watch ends or the stop channel is closed
+func WatchKansibleApps(c *client.Client, ns string, stop <-chan struct{}) (<-chan KansibleAppEvent, error) {
+	stream, err := c.RESTClient.Get().AbsPath(kansibleAppPath(ns)...).Param("watch", "true").Stream()
+	if err != nil {
+		return nil, err
+	}
+	events := make(chan KansibleAppEvent)
+	done := make(chan struct{})
+	go func() {
+		// closing the stream ends a decode which is waiting for the next event
+		select {
+		case <-stop:
+			stream.Close()
+		case <-done:
+		}
+	}()
+	go func() {
+		defer close(events)
+		defer close(done)
+		defer stream.Close()
+		decoder := json.NewDecoder(stream)
+		for {
+			event := KansibleAppEvent{}
+			err := decoder.Decode(&event)
+			if err != nil {
+				if err != io.EOF {
+					log.Debug("The watch of the KansibleApp resources in namespace %s ended: %s", ns, err)
+				}
+				return
+			}
+			select {
+			case events <- event:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return events, nil
+}
+
+// CreateKansibleApp creates the given KansibleApp resource
+func CreateKansibleApp(c *client.Client, ns string, app *KansibleApp) (*KansibleApp, error) {
+	return writeKansibleApp(c.RESTClient.Post().AbsPath(kansibleAppPath(ns)...), app)
+}
+
+// UpdateKansibleApp updates the given KansibleApp resource including its status
+func UpdateKansibleApp(c *client.Client, ns string, app *KansibleApp) (*KansibleApp, error) {
+	return writeKansibleApp(c.RESTClient.Put().AbsPath(kansibleAppPath(ns, app.ObjectMeta.Name)...), app)
+}
+
+func writeKansibleApp(request *restclient.Request, app *KansibleApp) (*KansibleApp, error) {
+	app.TypeMeta = unversioned.TypeMeta{
+		Kind:       KansibleAppKind,
+		APIVersion: KansibleAppGroup + "/" + KansibleAppVersion,
+	}
+	body, err := json.Marshal(app)
+	if err != nil {
+		return nil, err
+	}
+	data, err := request.SetHeader("Content-Type", "application/json").Body(body).DoRaw()
+	if err != nil {
+		return nil, err
+	}
+	answer := &KansibleApp{}
+	if err := json.Unmarshal(data, answer); err != nil {
+		return nil, err
+	}
+	return answer, nil
+}
+
+func kansibleAppPath(ns string, name ...string) []string {
+	return append([]string{"/apis", KansibleAppGroup, KansibleAppVersion, "namespaces", ns, KansibleAppResource}, name...)
+}
@@ -17,25 +17,201 @@
 package log
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/fatih/color"
+	"github.com/mattn/go-isatty"
 )
 
+const (
+	// FormatPlain is the human readable log format
+	FormatPlain = "plain"
+
+	// FormatJSON is the log format with a JSON object per line
+	FormatJSON = "json"
+)
+
+// Level is the severity of a log message
+type Level int
+
+const (
+	// LevelDebug is the level of debug messages which are only logged if IsDebugging is true
+	LevelDebug Level = iota
+	// LevelInfo is the level of informational messages
+	LevelInfo
+	// LevelWarn is the level of warning messages
+	LevelWarn
+	// LevelError is the level of error messages
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (level Level) String() string {
+	return levelNames[level]
+}
+
+// ParseLevel parses the name of a log level
+func ParseLevel(text string) (Level, error) {
+	name := strings.ToLower(strings.TrimSpace(text))
+	if name == "warning" {
+		name = "warn"
+	}
+	for i, levelName := range levelNames {
+		if name == levelName {
+			return Level(i), nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("Unknown log level `%s`; expected one of %s", text, strings.Join(levelNames, ", "))
+}
+
 var (
 	// IsDebugging toggles whether or not to enable debug output and behavior.
 	IsDebugging = false
 
 	// ErrorState denotes if application is in an error state.
 	ErrorState = false
+
+	// MinLevel is the minimum level of the messages which are logged; debug messages also require IsDebugging
+	MinLevel = LevelInfo
+
+	// Format is the format of the log messages; either FormatPlain or FormatJSON
+	Format = FormatPlain
+
+	// Output is where the log messages are written. It defaults to stderr so that stdout is a clean
+	// mirror of the output of the remote process
+	Output io.Writer = os.Stderr
+
+	lock sync.Mutex
+)
+
+// Configure configures the format and minimum level of the log messages and the file they are written to;
+// if the file is blank they are written to stderr. Colours are only used if the output is a terminal
+func Configure(format string, level string, file string) error {
+	format = strings.ToLower(strings.TrimSpace(format))
+	switch format {
+	case "":
+		format = FormatPlain
+	case FormatPlain, FormatJSON:
+	default:
+		return fmt.Errorf("Unknown log format `%s`; expected one of %s, %s", format, FormatPlain, FormatJSON)
+	}
+	Format = format
+
+	if len(strings.TrimSpace(level)) > 0 {
+		minLevel, err := ParseLevel(level)
+		if err != nil {
+			return err
+		}
+		MinLevel = minLevel
+		if minLevel == LevelDebug {
+			IsDebugging = true
+		}
+	}
+	if IsDebugging && MinLevel > LevelDebug {
+		MinLevel = LevelDebug
+	}
+
+	output := os.Stderr
+	if len(file) > 0 {
+		f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("Failed to open log file %s: %s", file, err)
+		}
+		output = f
+	}
+	Output = output
+	color.NoColor = Format != FormatPlain || !isatty.IsTerminal(output.Fd())
+	return nil
+}
+
+// Fields are the contextual fields of a log message such as the pod, namespace, RC, host and connection type
+// which are included in the JSON log messages so that the messages can be filtered
+type Fields map[string]string
+
+// Field names used for the context of the log messages
+const (
+	FieldPod        = "pod"
+	FieldNamespace  = "namespace"
+	FieldRC         = "rc"
+	FieldHost       = "host"
+	FieldAddress    = "address"
+	FieldConnection = "connection"
+	FieldPort       = "port"
 )
 
+// Logger logs levelled messages with contextual fields
+type Logger struct {
+	fields Fields
+}
+
+// std is the logger used by the package level functions whose fields are the context of the whole process
+var std = &Logger{fields: Fields{}}
+
+// SetFields adds the given fields to the context of all log messages; such as the pod or the host claimed by the pod
+func SetFields(fields Fields) {
+	lock.Lock()
+	defer lock.Unlock()
+	for name, value := range fields {
+		std.fields[name] = value
+	}
+}
+
+// WithFields returns a Logger which adds the given fields to the context of its log messages
+func WithFields(fields Fields) *Logger {
+	return std.WithFields(fields)
+}
+
+// WithFields returns a Logger which adds the given fields to the fields of this logger
+func (logger *Logger) WithFields(fields Fields) *Logger {
+	answer := &Logger{fields: Fields{}}
+	if logger != std {
+		for name, value := range logger.fields {
+			answer.fields[name] = value
+		}
+	}
+	for name, value := range fields {
+		answer.fields[name] = value
+	}
+	return answer
+}
+
+// Err logs an error message. It does not cause an exit.
+func (logger *Logger) Err(format string, v ...interface{}) {
+	logger.write(LevelError, color.RedString("[ERROR] "), format, v...)
+	ErrorState = true
+}
+
+// Info logs a green-tinted message.
+func (logger *Logger) Info(format string, v ...interface{}) {
+	logger.write(LevelInfo, color.GreenString("---> "), format, v...)
+}
+
+// Debug logs a cyan-tinted message if IsDebugging is true.
+func (logger *Logger) Debug(format string, v ...interface{}) {
+	if IsDebugging {
+		logger.write(LevelDebug, color.CyanString("[DEBUG] "), format, v...)
+	}
+}
+
+// Warn logs a yellow-tinted warning message.
+func (logger *Logger) Warn(format string, v ...interface{}) {
+	logger.write(LevelWarn, color.YellowString("[WARN] "), format, v...)
+}
+
 // Msg passes through the formatter, but otherwise prints exactly as-is.
 //
 // No prettification.
 func Msg(format string, v ...interface{}) {
-	fmt.Printf(appendNewLine(format), v...)
+	lock.Lock()
+	defer lock.Unlock()
+	fmt.Fprintf(Output, appendNewLine(format), v...)
 }
 
 // Die prints an error and then call os.Exit(1).
@@ -55,29 +231,51 @@ func CleanExit(format string, v ...interface{}) {
 
 // Err prints an error message. It does not cause an exit.
 func Err(format string, v ...interface{}) {
-	fmt.Print(color.RedString("[ERROR] "))
-	fmt.Printf(appendNewLine(format), v...)
-	ErrorState = true
+	std.Err(format, v...)
 }
 
 // Info prints a green-tinted message.
 func Info(format string, v ...interface{}) {
-	fmt.Print(color.GreenString("---> "))
-	fmt.Printf(appendNewLine(format), v...)
+	std.Info(format, v...)
 }
 
 // Debug prints a cyan-tinted message if IsDebugging is true.
 func Debug(format string, v ...interface{}) {
-	if IsDebugging {
-		fmt.Print(color.CyanString("[DEBUG] "))
-		fmt.Printf(appendNewLine(format), v...)
-	}
+	std.Debug(format, v...)
 }
 
 // Warn prints a yellow-tinted warning message.
 func Warn(format string, v ...interface{}) {
-	fmt.Print(color.YellowString("[WARN] "))
-	fmt.Printf(appendNewLine(format), v...)
+	std.Warn(format, v...)
+}
+
+// write writes the message in the configured format if its level is enabled. The fields are only included
+// in the JSON format to keep the plain format readable
+func (logger *Logger) write(level Level, prefix string, format string, v ...interface{}) {
+	if level < MinLevel {
+		return
+	}
+	message := fmt.Sprintf(format, v...)
+	lock.Lock()
+	defer lock.Unlock()
+	if Format == FormatJSON {
+		entry := map[string]string{}
+		for name, value := range std.fields {
+			entry[name] = value
+		}
+		for name, value := range logger.fields {
+			entry[name] = value
+		}
+		entry["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+		entry["level"] = level.String()
+		entry["msg"] = message
+		data, err := json.Marshal(entry)
+		if err == nil {
+			Output.Write(append(data, '\n'))
+			return
+		}
+	}
+	fmt.Fprint(Output, prefix+appendNewLine(message))
 }
 
 func appendNewLine(format string) string {
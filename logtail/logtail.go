@@ -0,0 +1,217 @@
+/*
+ * Copyright 2016 Red Hat
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package logtail
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/fabric8io/kansible/log"
+)
+
+const (
+	// DefaultRescanInterval is how often the glob patterns are expanded again to find new log files
+	DefaultRescanInterval = 30 * time.Second
+
+	maxRetryBackoff = 30 * time.Second
+)
+
+// Stdout is the stdout of the pod which is shared by the output of the remote process and the tailed log files so
+// that their writes are not interleaved
+var Stdout = NewSyncWriter(os.Stdout)
+
+// Follower follows log files on a remote host
+type Follower interface {
+	// List returns the files on the host which match the given glob patterns
+	List(patterns []string) ([]string, error)
+
+	// Follow copies the lines appended to the file to the writer, following the file if its rotated or truncated,
+	// until the file is removed or the connection to the host fails
+	Follow(file string, out io.Writer) error
+}
+
+// SyncWriter serialises the writes of several goroutines to the underlying writer
+type SyncWriter struct {
+	lock sync.Mutex
+	out  io.Writer
+}
+
+// NewSyncWriter creates a SyncWriter for the given writer
+func NewSyncWriter(out io.Writer) *SyncWriter {
+	return &SyncWriter{out: out}
+}
+
+func (w *SyncWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.out.Write(p)
+}
+
+// Tailer multiplexes the log files on a remote host which match the glob patterns into a single writer
+type Tailer struct {
+	Follower       Follower
+	Patterns       []string
+	Prefix         bool
+	RescanInterval time.Duration
+
+	out       *SyncWriter
+	lock      sync.Mutex
+	following map[string]bool
+}
+
+// NewTailer creates a Tailer which writes the lines of the matching log files to the given writer; if prefix is
+// true each line is prefixed with the name of its file. Pass a SyncWriter which is shared with any other output
+// written to the same destination, such as Stdout, so that the lines are not interleaved with it
+func NewTailer(follower Follower, patterns []string, prefix bool, out io.Writer) *Tailer {
+	syncWriter, ok := out.(*SyncWriter)
+	if !ok {
+		syncWriter = NewSyncWriter(out)
+	}
+	return &Tailer{
+		Follower:       follower,
+		Patterns:       patterns,
+		Prefix:         prefix,
+		RescanInterval: DefaultRescanInterval,
+		out:            syncWriter,
+		following:      map[string]bool{},
+	}
+}
+
+// Run follows the matching log files forever, periodically looking for new files which match the patterns
+func (t *Tailer) Run() {
+	for {
+		files, err := t.Follower.List(t.Patterns)
+		if err != nil {
+			log.Warn("Failed to find the log files matching %v: %s", t.Patterns, err)
+		}
+		for _, file := range files {
+			if t.startFollowing(file) {
+				go t.follow(file)
+			}
+		}
+		time.Sleep(t.RescanInterval)
+	}
+}
+
+// startFollowing returns true if the file is not already being followed
+func (t *Tailer) startFollowing(file string) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.following[file] {
+		return false
+	}
+	t.following[file] = true
+	return true
+}
+
+// stopFollowing forgets the file so that its followed again if it matches the patterns in a later rescan
+func (t *Tailer) stopFollowing(file string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	delete(t.following, file)
+}
+
+// matches returns true if the file still matches the patterns; or if the files cannot be listed
+func (t *Tailer) matches(file string) bool {
+	files, err := t.Follower.List(t.Patterns)
+	if err != nil {
+		return true
+	}
+	for _, f := range files {
+		if f == file {
+			return true
+		}
+	}
+	return false
+}
+
+// follow follows the file until it no longer matches the patterns, retrying with a backoff if the connection fails
+func (t *Tailer) follow(file string) {
+	log.Info("Tailing log file %s", file)
+	prefix := ""
+	if t.Prefix {
+		prefix = "[" + file + "] "
+	}
+	writer := &lineWriter{out: t.out, prefix: prefix}
+	backoff := time.Second
+	for {
+		started := time.Now()
+		err := t.Follower.Follow(file, writer)
+		writer.flush()
+		if !t.matches(file) {
+			log.Info("Stopped tailing log file %s as it no longer exists", file)
+			t.stopFollowing(file)
+			return
+		}
+		if err == nil || time.Since(started) > maxRetryBackoff {
+			backoff = time.Second
+		}
+		log.Warn("Stopped tailing log file %s, retrying in %s: %v", file, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+}
+
+// lineWriter writes whole lines to the shared writer so that the lines of different files are not interleaved
+type lineWriter struct {
+	out    io.Writer
+	prefix string
+	buffer []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buffer = append(w.buffer, p...)
+	idx := bytes.LastIndexByte(w.buffer, '\n')
+	if idx < 0 {
+		return len(p), nil
+	}
+	lines := w.buffer[:idx+1]
+	err := w.write(lines)
+	w.buffer = append([]byte{}, w.buffer[idx+1:]...)
+	return len(p), err
+}
+
+// flush writes any remaining partial line
+func (w *lineWriter) flush() {
+	if len(w.buffer) > 0 {
+		w.write(append(w.buffer, '\n'))
+		w.buffer = nil
+	}
+}
+
+// write writes the lines to the shared writer in a single write
+func (w *lineWriter) write(lines []byte) error {
+	if len(w.prefix) == 0 {
+		_, err := w.out.Write(lines)
+		return err
+	}
+	var buffer bytes.Buffer
+	for _, line := range bytes.SplitAfter(lines, []byte{'\n'}) {
+		if len(line) > 0 {
+			buffer.WriteString(w.prefix)
+			buffer.Write(line)
+		}
+	}
+	_, err := w.out.Write(buffer.Bytes())
+	return err
+}
@@ -0,0 +1,202 @@
+/*
+ * Copyright 2016 Red Hat
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metrics
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/fabric8io/kansible/log"
+)
+
+const (
+	// MetricsPath is the path of the Prometheus metrics endpoint
+	MetricsPath = "/metrics"
+
+	namespace = "kansible"
+
+	// ClaimResultClaimed is the result of a claim attempt which claimed a host
+	ClaimResultClaimed = "claimed"
+	// ClaimResultConflict is the result of a claim attempt which failed due to a concurrent update of the RC
+	ClaimResultConflict = "conflict"
+	// ClaimResultUnavailable is the result of a claim attempt when no hosts were available
+	ClaimResultUnavailable = "unavailable"
+)
+
+var (
+	connected = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "connected",
+		Help:      "Whether the SSH or WinRM session to the host is connected.",
+	})
+	reconnects = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reconnects_total",
+		Help:      "The number of times the connection to the host has been re-established.",
+	})
+	processUp = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "remote_process_up",
+		Help:      "Whether the remote process is running.",
+	})
+	processStarts = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "remote_process_starts_total",
+		Help:      "The number of times the remote process has been started or re-attached to.",
+	})
+	processStartTime = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "remote_process_start_time_seconds",
+		Help:      "The start time of the remote process since the unix epoch in seconds.",
+	})
+	processUptime = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "remote_process_uptime_seconds",
+		Help:      "How long the remote process has been running in seconds.",
+	}, uptime)
+	processCPU = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "remote_process_cpu_seconds_total",
+		Help:      "The total CPU time used by the remote process in seconds.",
+	})
+	processRSS = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "remote_process_resident_memory_bytes",
+		Help:      "The resident memory size of the remote process in bytes.",
+	})
+	forwardedBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "forwarded_bytes_total",
+		Help:      "The number of bytes forwarded for each port by direction.",
+	}, []string{"port", "direction"})
+	forwardedConnections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "forwarded_connections_total",
+		Help:      "The number of connections accepted for each forwarded port.",
+	}, []string{"port"})
+	claimAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "host_claim_attempts_total",
+		Help:      "The number of attempts to claim a host by result.",
+	}, []string{"result"})
+
+	lock      sync.Mutex
+	startTime time.Time
+	isUp      bool
+)
+
+func init() {
+	prometheus.MustRegister(connected)
+	prometheus.MustRegister(reconnects)
+	prometheus.MustRegister(processUp)
+	prometheus.MustRegister(processStarts)
+	prometheus.MustRegister(processStartTime)
+	prometheus.MustRegister(processUptime)
+	prometheus.MustRegister(processCPU)
+	prometheus.MustRegister(processRSS)
+	prometheus.MustRegister(forwardedBytes)
+	prometheus.MustRegister(forwardedConnections)
+	prometheus.MustRegister(claimAttempts)
+}
+
+// Handle registers the metrics endpoint on the given mux
+func Handle(mux *http.ServeMux) {
+	mux.Handle(MetricsPath, prometheus.Handler())
+}
+
+// SetConnected records whether the session to the host is connected
+func SetConnected(value bool) {
+	connected.Set(boolValue(value))
+}
+
+// Reconnected records that the connection to the host was re-established
+func Reconnected() {
+	reconnects.Inc()
+}
+
+// SetProcessUp records whether the remote process is running
+func SetProcessUp(up bool) {
+	lock.Lock()
+	defer lock.Unlock()
+	if up && !isUp {
+		startTime = time.Now()
+		processStarts.Inc()
+		processStartTime.Set(float64(startTime.Unix()))
+	}
+	isUp = up
+	processUp.Set(boolValue(up))
+}
+
+// SetProcessUsage records the total CPU time in seconds and the resident memory size in bytes of the remote process
+func SetProcessUsage(cpuSeconds float64, rssBytes float64) {
+	processCPU.Set(cpuSeconds)
+	processRSS.Set(rssBytes)
+}
+
+// ClaimAttempt records an attempt to claim a host with the given result
+func ClaimAttempt(result string) {
+	claimAttempts.WithLabelValues(result).Inc()
+}
+
+// ForwardedConnection records a connection accepted for the forwarded port
+func ForwardedConnection(port string) {
+	forwardedConnections.WithLabelValues(port).Inc()
+}
+
+// ForwardedBytes returns the counter of bytes forwarded for the port in the direction; either `in` for
+// bytes sent to the host or `out` for bytes received from the host
+func ForwardedBytes(port string, direction string) prometheus.Counter {
+	return forwardedBytes.WithLabelValues(port, direction)
+}
+
+// ProcessSampler samples the resource usage of the remote process
+type ProcessSampler interface {
+	// Sample returns the total CPU time in seconds and the resident memory size in bytes of the remote process
+	Sample() (float64, float64, error)
+}
+
+// SampleProcess records the resource usage of the remote process every interval forever
+func SampleProcess(sampler ProcessSampler, interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		cpuSeconds, rssBytes, err := sampler.Sample()
+		if err != nil {
+			log.Debug("Failed to sample the resource usage of the remote process: %s", err)
+			continue
+		}
+		SetProcessUsage(cpuSeconds, rssBytes)
+	}
+}
+
+func uptime() float64 {
+	lock.Lock()
+	defer lock.Unlock()
+	if !isUp {
+		return 0
+	}
+	return time.Since(startTime).Seconds()
+}
+
+func boolValue(value bool) float64 {
+	if value {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,192 @@
+/*
+ * Copyright 2016 Red Hat
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ssh
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/fabric8io/kansible/log"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// CertificateSuffix is the suffix of the OpenSSH user certificate file next to a private key file
+const CertificateSuffix = "-cert.pub"
+
+var (
+	// KeyPassphrase is the passphrase used to decrypt passphrase protected private keys
+	KeyPassphrase = ""
+
+	// AgentSocket is the unix socket of the ssh-agent used to authenticate; if its blank no agent is used
+	AgentSocket = ""
+
+	// ForwardAgent enables forwarding the ssh-agent to the remote command
+	ForwardAgent = false
+)
+
+// authentication are the auth methods for a connection along with their names for error messages
+type authentication struct {
+	methods []ssh.AuthMethod
+	names   []string
+	closers []io.Closer
+}
+
+func (a *authentication) add(name string, method ssh.AuthMethod) {
+	a.names = append(a.names, name)
+	a.methods = append(a.methods, method)
+}
+
+// Close closes any connection to the ssh-agent
+func (a *authentication) Close() {
+	for _, closer := range a.closers {
+		closer.Close()
+	}
+}
+
+// newAuthentication creates the auth methods for the private key file with its OpenSSH certificate if there is one,
+// the ssh-agent and the password
+func newAuthentication(privateKey string, password string) (*authentication, error) {
+	auth := &authentication{}
+	if len(privateKey) > 0 {
+		signers, err := loadSigners(privateKey, KeyPassphrase)
+		if err != nil {
+			return nil, err
+		}
+		auth.add("private key "+privateKey, ssh.PublicKeys(signers...))
+	}
+	if len(AgentSocket) > 0 {
+		conn, err := net.Dial("unix", AgentSocket)
+		if err != nil {
+			log.Warn("Failed to connect to the ssh-agent on %s: %s", AgentSocket, err)
+		} else {
+			auth.closers = append(auth.closers, conn)
+			auth.add("ssh-agent", ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		}
+	}
+	if len(password) > 0 {
+		auth.add("password", ssh.Password(password))
+		auth.add("keyboard-interactive", ssh.KeyboardInteractive(passwordChallenge(password)))
+	}
+	if len(auth.methods) == 0 {
+		auth.Close()
+		return nil, fmt.Errorf("No SSH authentication method is available; specify a private key via ansible_ssh_private_key_file, a password via ansible_ssh_pass or run an ssh-agent")
+	}
+	return auth, nil
+}
+
+// passwordChallenge answers the keyboard-interactive questions which ask for the password with the password;
+// any other question such as a one time password or a new password is refused so the password is not sent in reply
+func passwordChallenge(password string) ssh.KeyboardInteractiveChallenge {
+	return func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+		answers := make([]string, len(questions))
+		for i, question := range questions {
+			if !isPasswordPrompt(question, echos[i]) {
+				return nil, fmt.Errorf("Cannot answer the keyboard-interactive question %q for %s; only password prompts are supported", strings.TrimSpace(question), user)
+			}
+			answers[i] = password
+		}
+		return answers, nil
+	}
+}
+
+// otherPasswordPrompt matches the questions for a one time password or for changing an expired password
+var otherPasswordPrompt = regexp.MustCompile(`\b(new|retype|re-enter|confirm|verify|one-time|otp)\b`)
+
+// isPasswordPrompt returns true if the keyboard-interactive question asks for the current password without echoing it
+func isPasswordPrompt(question string, echo bool) bool {
+	text := strings.ToLower(question)
+	return !echo && strings.Contains(text, "password") && !otherPasswordPrompt.MatchString(text)
+}
+
+// loadSigners loads the private key file and if there is an OpenSSH user certificate next to it the certificate
+// signer is returned before the signer of the plain key
+func loadSigners(file string, passphrase string) ([]ssh.Signer, error) {
+	signer, err := loadPrivateKey(file, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	certFile := file + CertificateSuffix
+	data, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []ssh.Signer{signer}, nil
+		}
+		return nil, fmt.Errorf("Failed to read the certificate %s: %s", certFile, err)
+	}
+	key, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse the certificate %s: %s", certFile, err)
+	}
+	cert, ok := key.(*ssh.Certificate)
+	if !ok || cert.CertType != ssh.UserCert {
+		return nil, fmt.Errorf("The file %s is not an OpenSSH user certificate", certFile)
+	}
+	certSigner, err := ssh.NewCertSigner(cert, signer)
+	if err != nil {
+		return nil, fmt.Errorf("The certificate %s does not match the private key %s: %s", certFile, file, err)
+	}
+	return []ssh.Signer{certSigner, signer}, nil
+}
+
+// loadPrivateKey loads the PEM encoded private key file decrypting it with the passphrase if its encrypted
+func loadPrivateKey(file string, passphrase string) (ssh.Signer, error) {
+	buffer, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read the private key %s: %s", file, err)
+	}
+	block, _ := pem.Decode(buffer)
+	if block == nil {
+		return nil, fmt.Errorf("The private key %s is not a PEM encoded key", file)
+	}
+	if block.Type == "OPENSSH PRIVATE KEY" {
+		return nil, fmt.Errorf("The private key %s is in the new OpenSSH format which is not supported; convert it with `ssh-keygen -p -m PEM -f %s`", file, file)
+	}
+	if x509.IsEncryptedPEMBlock(block) {
+		if len(passphrase) == 0 {
+			return nil, fmt.Errorf("The private key %s is protected by a passphrase but no passphrase was specified", file)
+		}
+		der, err := x509.DecryptPEMBlock(block, []byte(passphrase))
+		if err != nil {
+			if err == x509.IncorrectPasswordError {
+				return nil, fmt.Errorf("The passphrase of the private key %s is incorrect", file)
+			}
+			return nil, fmt.Errorf("Failed to decrypt the private key %s: %s", file, err)
+		}
+		buffer = pem.EncodeToMemory(&pem.Block{Type: block.Type, Bytes: der})
+	}
+	signer, err := ssh.ParsePrivateKey(buffer)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse the private key %s: %s", file, err)
+	}
+	return signer, nil
+}
+
+// authError returns a clear error if the connection failed due to authentication
+func authError(err error, user string, hostPort string, auth *authentication) error {
+	if strings.Contains(err.Error(), "unable to authenticate") {
+		return fmt.Errorf("Failed to authenticate as %s on %s using %s: %s", user, hostPort, strings.Join(auth.names, ", "), err)
+	}
+	return fmt.Errorf("Failed to dial: %s", err)
+}
@@ -0,0 +1,65 @@
+/*
+ * Copyright 2016 Red Hat
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ssh
+
+import (
+	"testing"
+)
+
+func TestPasswordChallenge(t *testing.T) {
+	tests := []struct {
+		questions []string
+		echos     []bool
+		answered  bool
+	}{
+		{[]string{}, []bool{}, true},
+		{[]string{"Password: "}, []bool{false}, true},
+		{[]string{"admin@app1's password: "}, []bool{false}, true},
+		{[]string{"(current) UNIX password: "}, []bool{false}, true},
+		{[]string{"Password: "}, []bool{true}, false},
+		{[]string{"Verification code: "}, []bool{false}, false},
+		{[]string{"One-time password (OATH) for `admin': "}, []bool{true}, false},
+		{[]string{"One-time password (OATH) for `admin': "}, []bool{false}, false},
+		{[]string{"OTP password: "}, []bool{false}, false},
+		{[]string{"Password: ", "Verification code: "}, []bool{false, false}, false},
+		{[]string{"New password: "}, []bool{false}, false},
+		{[]string{"Enter new UNIX password: "}, []bool{false}, false},
+		{[]string{"Retype new UNIX password: "}, []bool{false}, false},
+	}
+	challenge := passwordChallenge("secret")
+	for _, test := range tests {
+		answers, err := challenge("admin", "", test.questions, test.echos)
+		if !test.answered {
+			if err == nil {
+				t.Errorf("Expected the questions %q not to be answered", test.questions)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Expected the questions %q to be answered but got: %s", test.questions, err)
+			continue
+		}
+		if len(answers) != len(test.questions) {
+			t.Errorf("Expected %d answers to the questions %q but got %d", len(test.questions), test.questions, len(answers))
+		}
+		for _, answer := range answers {
+			if answer != "secret" {
+				t.Errorf("Expected the password as the answer to the questions %q but got %q", test.questions, answer)
+			}
+		}
+	}
+}
@@ -0,0 +1,271 @@
+/*
+ * Copyright 2016 Red Hat
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ssh
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// BecomeSudo runs commands as the become user via sudo
+	BecomeSudo = "sudo"
+
+	// BecomeSu runs commands as the become user via su
+	BecomeSu = "su"
+
+	// BecomePbrun runs commands as the become user via PowerBroker pbrun
+	BecomePbrun = "pbrun"
+
+	// BecomeDzdo runs commands as the become user via Centrify dzdo
+	BecomeDzdo = "dzdo"
+
+	// DefaultBecomeUser is the user commands are run as if no become user is specified
+	DefaultBecomeUser = "root"
+
+	// becomePrompt is the password prompt passed to sudo and dzdo so that it can be told apart from the output
+	becomePrompt = "[kansible become password]"
+
+	// maxPromptLength is how much output is buffered while looking for the password prompt
+	maxPromptLength = 256
+
+	// promptTimeout is how long to wait for the password prompt before passing on stdin
+	promptTimeout = 5 * time.Second
+)
+
+var (
+	becomeLock  sync.Mutex
+	becomeHosts = map[string]*Become{}
+)
+
+// Become describes how commands are run as a different user on a host like the become settings of Ansible
+type Become struct {
+	Method   string
+	User     string
+	Password string
+}
+
+// NewBecome creates a Become for the given method, user and password; the method defaults to sudo and the user to root
+func NewBecome(method string, user string, password string) (*Become, error) {
+	method = strings.ToLower(strings.TrimSpace(method))
+	switch method {
+	case "":
+		method = BecomeSudo
+	case BecomeSudo, BecomeSu, BecomePbrun, BecomeDzdo:
+	default:
+		return nil, fmt.Errorf("Unsupported become method `%s`; expected one of %s, %s, %s or %s", method, BecomeSudo, BecomeSu, BecomePbrun, BecomeDzdo)
+	}
+	if len(user) == 0 {
+		user = DefaultBecomeUser
+	}
+	return &Become{Method: method, User: user, Password: password}, nil
+}
+
+func (b *Become) String() string {
+	return b.Method + " " + b.User
+}
+
+// SetBecome sets how the supervised command, probes, log tailers and stop script on the host are run as a
+// different user; nil runs them as the SSH user
+func SetBecome(host string, become *Become) {
+	becomeLock.Lock()
+	defer becomeLock.Unlock()
+	if become == nil {
+		delete(becomeHosts, host)
+	} else {
+		becomeHosts[host] = become
+	}
+}
+
+func getBecome(host string) *Become {
+	becomeLock.Lock()
+	defer becomeLock.Unlock()
+	return becomeHosts[host]
+}
+
+// Command returns the command which runs the given command as the become user via a POSIX shell; or the command
+// itself if there is no become user
+func (b *Become) Command(cmd string) string {
+	if b == nil {
+		return cmd
+	}
+	shell := "sh -c " + shellQuote(cmd)
+	user := shellQuote(b.User)
+	switch b.Method {
+	case BecomeSu:
+		return "su " + user + " -c " + shellQuote(shell)
+	case BecomePbrun:
+		return "pbrun -u " + user + " " + shell
+	}
+	options := " -H -n"
+	if len(b.Password) > 0 {
+		options = " -H -S -p " + shellQuote(becomePrompt)
+	}
+	return b.Method + options + " -u " + user + " " + shell
+}
+
+// needsPty returns true if the password prompt of the become method is read from a terminal
+func (b *Become) needsPty() bool {
+	return len(b.Password) > 0 && (b.Method == BecomeSu || b.Method == BecomePbrun)
+}
+
+// isPrompt returns true if the output is the password prompt of the become method
+func (b *Become) isPrompt(text string) bool {
+	if b.Method == BecomeSudo || b.Method == BecomeDzdo {
+		return strings.Contains(text, becomePrompt)
+	}
+	text = strings.TrimSpace(text)
+	return strings.HasSuffix(text, ":") && strings.Contains(strings.ToLower(text), "asswor")
+}
+
+// attach connects the stdin, stdout and stderr of the session before its started, answering the password prompt
+// of the become method with the password over the session. Any of the streams may be nil. The returned function
+// must be called once the command completes to write any output buffered while looking for the prompt.
+// Pass true for pty if the session already has a pseudo terminal
+func (b *Become) attach(session *ssh.Session, stdin io.Reader, stdout io.Writer, stderr io.Writer, pty bool) (func(), error) {
+	var pipe io.WriteCloser
+	var err error
+	if stdin != nil || (b != nil && len(b.Password) > 0) {
+		pipe, err = session.StdinPipe()
+		if err != nil {
+			return nil, fmt.Errorf("Unable to setup stdin for session: %v", err)
+		}
+	}
+	if b == nil || len(b.Password) == 0 {
+		session.Stdout = stdout
+		session.Stderr = stderr
+		if stdin != nil {
+			go io.Copy(pipe, stdin)
+		}
+		return func() {}, nil
+	}
+
+	if b.needsPty() && !pty {
+		modes := ssh.TerminalModes{
+			ssh.ECHO:          0,
+			ssh.TTY_OP_ISPEED: 14400, // input speed = 14.4kbaud
+			ssh.TTY_OP_OSPEED: 14400, // output speed = 14.4kbaud
+		}
+		err = session.RequestPty(defaultTerm, defaultTermHeight, defaultTermWidth, modes)
+		if err != nil {
+			return nil, fmt.Errorf("Request for pseudo terminal for the %s password prompt failed: %s", b.Method, err)
+		}
+	}
+
+	ready := make(chan struct{})
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			close(ready)
+			if stdin == nil && !b.needsPty() {
+				// lets make sudo fail rather than wait for another attempt if the password is wrong
+				pipe.Close()
+			}
+		})
+	}
+	responder := &promptResponder{
+		become: b,
+		answer: func() {
+			io.WriteString(pipe, b.Password+"\n")
+			release()
+		},
+		release: release,
+	}
+	if b.needsPty() || pty {
+		// the prompt is written to the terminal which is the stdout of the session
+		responder.out = stdout
+		session.Stdout = responder
+		session.Stderr = stderr
+	} else {
+		responder.out = stderr
+		session.Stdout = stdout
+		session.Stderr = responder
+	}
+	if stdin != nil {
+		go func() {
+			select {
+			case <-ready:
+			case <-time.After(promptTimeout):
+			}
+			io.Copy(pipe, stdin)
+		}()
+	}
+	return responder.flush, nil
+}
+
+// promptResponder passes on the output written to it apart from the password prompt of the become method which is
+// answered instead. Only the start of the output is checked for the prompt
+type promptResponder struct {
+	become  *Become
+	out     io.Writer
+	answer  func()
+	release func()
+
+	lock   sync.Mutex
+	buffer []byte
+	done   bool
+}
+
+func (r *promptResponder) Write(p []byte) (int, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.done {
+		return r.write(p)
+	}
+	r.buffer = append(r.buffer, p...)
+	text := string(r.buffer)
+	if r.become.isPrompt(text) {
+		r.done = true
+		r.buffer = nil
+		r.answer()
+		return len(p), nil
+	}
+	if strings.Contains(text, "\n") || len(r.buffer) > maxPromptLength {
+		// the output is not a prompt so the become method did not need the password
+		r.done = true
+		r.release()
+		buffer := r.buffer
+		r.buffer = nil
+		_, err := r.write(buffer)
+		return len(p), err
+	}
+	return len(p), nil
+}
+
+// flush writes any output which was buffered while looking for the prompt
+func (r *promptResponder) flush() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.done = true
+	r.release()
+	if len(r.buffer) > 0 {
+		r.write(r.buffer)
+		r.buffer = nil
+	}
+}
+
+func (r *promptResponder) write(p []byte) (int, error) {
+	if r.out == nil {
+		return len(p), nil
+	}
+	return r.out.Write(p)
+}
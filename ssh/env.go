@@ -0,0 +1,74 @@
+/*
+ * Copyright 2016 Red Hat
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ssh
+
+import (
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/fabric8io/kansible/log"
+)
+
+// MaskedValue replaces the values of environment variables in log messages
+const MaskedValue = "******"
+
+// setEnv sets the environment variables on the session returning those which were rejected by the sshd, which
+// by default only accepts the variables listed in its `AcceptEnv` setting, so they can be passed via envCommand
+func setEnv(session *ssh.Session, envVars map[string]string, logger *log.Logger) map[string]string {
+	rejected := map[string]string{}
+	for _, name := range sortedNames(envVars) {
+		logger.Info("Setting environment variable %s", name)
+		err := session.Setenv(name, envVars[name])
+		if err != nil {
+			logger.Debug("The sshd rejected environment variable %s so passing it on the command line instead: %s", name, err)
+			rejected[name] = envVars[name]
+		}
+	}
+	return rejected
+}
+
+// envCommand returns the command which runs the given command with the environment variables via `env`
+func envCommand(envVars map[string]string, cmd string) string {
+	if len(envVars) == 0 {
+		return cmd
+	}
+	args := []string{"env"}
+	for _, name := range sortedNames(envVars) {
+		args = append(args, shellQuote(name+"="+envVars[name]))
+	}
+	return strings.Join(args, " ") + " sh -c " + shellQuote(cmd)
+}
+
+// maskEnv returns the environment variables with their values masked for use in log messages
+func maskEnv(envVars map[string]string) map[string]string {
+	answer := map[string]string{}
+	for name := range envVars {
+		answer[name] = MaskedValue
+	}
+	return answer
+}
+
+func sortedNames(envVars map[string]string) []string {
+	names := []string{}
+	for name := range envVars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,167 @@
+/*
+ * Copyright 2016 Red Hat
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ssh
+
+import (
+	"net"
+	"os/exec"
+	"reflect"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/fabric8io/kansible/log"
+)
+
+func TestEnvCommand(t *testing.T) {
+	tests := []struct {
+		envVars  map[string]string
+		cmd      string
+		expected string
+	}{
+		{nil, "run.sh", "run.sh"},
+		{map[string]string{"A": "1"}, "run.sh", `env 'A=1' sh -c 'run.sh'`},
+		{map[string]string{"B": "2", "A": "1"}, "run.sh", `env 'A=1' 'B=2' sh -c 'run.sh'`},
+		{map[string]string{"A": "it's"}, "run.sh", `env 'A=it'\''s' sh -c 'run.sh'`},
+		{map[string]string{"A": "$HOME"}, "echo $A", `env 'A=$HOME' sh -c 'echo $A'`},
+		{map[string]string{"A": "a b!"}, "run.sh", `env 'A=a b!' sh -c 'run.sh'`},
+		{map[string]string{"A": "line1\nline2"}, "run.sh", "env 'A=line1\nline2' sh -c 'run.sh'"},
+		{map[string]string{"A": "~/app"}, "run.sh", `env 'A=~/app' sh -c 'run.sh'`},
+	}
+	for _, test := range tests {
+		actual := envCommand(test.envVars, test.cmd)
+		if actual != test.expected {
+			t.Errorf("envCommand(%q, %q): expected `%s` but got `%s`", test.envVars, test.cmd, test.expected, actual)
+		}
+	}
+}
+
+func TestEnvCommandPassesTheValuesUnchanged(t *testing.T) {
+	values := []string{
+		"plain",
+		"it's",
+		`say "it's done"`,
+		"$HOME and `id` and $(id)",
+		"a b!c",
+		"line1\nline2",
+		"~/app",
+		`back\slash`,
+		"",
+	}
+	for _, value := range values {
+		cmd := envCommand(map[string]string{"KANSIBLE_TEST": value}, `printf %s "$KANSIBLE_TEST"`)
+		output, err := exec.Command("sh", "-c", cmd).Output()
+		if err != nil {
+			t.Errorf("Value %q: failed to run `%s`: %s", value, cmd, err)
+			continue
+		}
+		if string(output) != value {
+			t.Errorf("Value %q: expected `%s` to print the value but got %q", value, cmd, string(output))
+		}
+	}
+}
+
+func TestMaskEnv(t *testing.T) {
+	actual := maskEnv(map[string]string{"A": "secret", "B": ""})
+	expected := map[string]string{"A": MaskedValue, "B": MaskedValue}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected %v but got %v", expected, actual)
+	}
+	cmd := envCommand(actual, "run.sh")
+	if cmd != `env 'A=******' 'B=******' sh -c 'run.sh'` {
+		t.Errorf("Expected the masked values in the command but got `%s`", cmd)
+	}
+}
+
+// acceptEnvServer serves SSH sessions which only accept the environment variables with the given names like
+// the `AcceptEnv` setting of sshd
+func acceptEnvServer(t *testing.T, conn net.Conn, acceptEnv ...string) {
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(newTestSigner(t))
+	_, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(requests)
+	for newChannel := range channels {
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer channel.Close()
+			for request := range channelRequests {
+				accepted := false
+				if request.Type == "env" {
+					var env struct {
+						Name  string
+						Value string
+					}
+					if ssh.Unmarshal(request.Payload, &env) == nil {
+						for _, name := range acceptEnv {
+							accepted = accepted || name == env.Name
+						}
+					}
+				}
+				if request.WantReply {
+					request.Reply(accepted, nil)
+				}
+			}
+		}()
+	}
+}
+
+func TestSetEnvFallsBackToTheCommandLine(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			acceptEnvServer(t, conn, "LANG")
+		}
+	}()
+	client, err := ssh.Dial("tcp", listener.Addr().String(), &ssh.ClientConfig{
+		User:            "deploy",
+		HostKeyCallback: func(string, net.Addr, ssh.PublicKey) error { return nil },
+	})
+	if err != nil {
+		t.Fatalf("Failed to connect: %s", err)
+	}
+	defer client.Close()
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatalf("Failed to create session: %s", err)
+	}
+	defer session.Close()
+
+	envVars := map[string]string{
+		"LANG":     "en_US.UTF-8",
+		"PASSWORD": "it's $ecret",
+	}
+	rejected := setEnv(session, envVars, log.WithFields(log.Fields{}))
+	expected := map[string]string{"PASSWORD": "it's $ecret"}
+	if !reflect.DeepEqual(rejected, expected) {
+		t.Errorf("Expected the rejected environment variables %v but got %v", expected, rejected)
+	}
+	cmd := envCommand(rejected, "run.sh")
+	if cmd != `env 'PASSWORD=it'\''s $ecret' sh -c 'run.sh'` {
+		t.Errorf("Expected the rejected environment variables to be passed via env but got `%s`", cmd)
+	}
+}
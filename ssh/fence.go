@@ -0,0 +1,73 @@
+/*
+ * Copyright 2016 Red Hat
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ssh
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/fabric8io/kansible/log"
+)
+
+const (
+	// UnknownOwner is the owner reported when a leftover process was started without recording its owner
+	UnknownOwner = "unknown"
+)
+
+// FenceRemoteProcess makes the given owner the owner of the state directory on the host. If a process started by a
+// different owner is still running it is stopped using the stop policy first so that two processes are never
+// running for the same host. Returns the name of the previous owner if a leftover process was stopped
+func FenceRemoteProcess(user string, privateKey string, password string, host string, port string, stateDir string, owner string, policy StopPolicy) (string, error) {
+	client, err := Dial(user, privateKey, password, host, port)
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+
+	ownerFile := stateFile(stateDir, "owner")
+	pidFile := stateFile(stateDir, "pid")
+	// prints the previous owner if its process is still running
+	checkScript := `[ -e ` + pidFile + ` ] || exit 0
+PID=$(cat ` + pidFile + `) || exit 1
+[ -n "$PID" ] || exit 0
+[ -f ` + stateFile(stateDir, "exit") + ` ] && exit 0
+kill -0 $PID 2>/dev/null || exit 0
+OWNER=$(cat ` + ownerFile + ` 2>/dev/null)
+[ "$OWNER" = ` + shellQuote(owner) + ` ] && exit 0
+echo "${OWNER:-` + UnknownOwner + `}"`
+	var stdout bytes.Buffer
+	err = runStateScript(client, host, checkScript, &stdout, nil)
+	if err != nil {
+		return "", fmt.Errorf("Failed to check for a leftover process on host %s: %v", host, err)
+	}
+
+	previousOwner := strings.TrimSpace(stdout.String())
+	if len(previousOwner) > 0 {
+		log.Warn("Found a leftover process on host %s started by %s so stopping it", host, previousOwner)
+		err = stopProcess(client, host, stateDir, policy)
+		if err != nil {
+			return previousOwner, err
+		}
+	}
+
+	err = runStateScript(client, host, "mkdir -p "+stateDirPath(stateDir)+" && echo "+shellQuote(owner)+" > "+ownerFile, nil, nil)
+	if err != nil {
+		return previousOwner, fmt.Errorf("Failed to record the owner of %s on host %s: %v", stateDir, host, err)
+	}
+	return previousOwner, nil
+}
@@ -0,0 +1,82 @@
+/*
+ * Copyright 2016 Red Hat
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ssh
+
+import (
+	"net"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Forwarder opens connections to ports on a host as `direct-tcpip` channels over a persistent SSH connection,
+// like `ssh -L`, so that only the SSH port of the host needs to be reachable. The connection is re-established
+// if it fails
+type Forwarder struct {
+	User       string
+	PrivateKey string
+	Password   string
+	Host       string
+	Port       string
+
+	lock   sync.Mutex
+	client *ssh.Client
+}
+
+// Dial opens a connection to the address as seen from the host; e.g. `127.0.0.1:8080` for a port which is only
+// bound to the loopback interface of the host
+func (f *Forwarder) Dial(address string) (net.Conn, error) {
+	for attempt := 0; ; attempt++ {
+		client, err := f.connect()
+		if err != nil {
+			return nil, err
+		}
+		conn, err := client.Dial("tcp", address)
+		if err == nil {
+			return conn, nil
+		}
+		if _, ok := err.(*ssh.OpenChannelError); ok || attempt > 0 {
+			// the host refused the connection to the port so there is no need to reconnect
+			return nil, err
+		}
+		f.reset(client)
+	}
+}
+
+// connect returns the SSH connection to the host; connecting if there is none
+func (f *Forwarder) connect() (*ssh.Client, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.client == nil {
+		client, err := Dial(f.User, f.PrivateKey, f.Password, f.Host, f.Port)
+		if err != nil {
+			return nil, err
+		}
+		f.client = client
+	}
+	return f.client, nil
+}
+
+// reset closes the failed connection so that the next connection to a port reconnects
+func (f *Forwarder) reset(client *ssh.Client) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.client == client {
+		f.client.Close()
+		f.client = nil
+	}
+}
@@ -0,0 +1,387 @@
+/*
+ * Copyright 2016 Red Hat
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ssh
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const tunnelDialTimeout = 10 * time.Second
+
+// JumpHost is a bastion host through which SSH connections to a host are tunneled
+type JumpHost struct {
+	User       string
+	PrivateKey string
+	Password   string
+	Host       string
+	Port       string
+}
+
+func (j JumpHost) String() string {
+	return j.User + "@" + net.JoinHostPort(j.Host, j.Port)
+}
+
+var (
+	jumpLock  sync.Mutex
+	jumpHosts = map[string][]JumpHost{}
+	tunnels   = map[string]*tunnel{}
+)
+
+// tunnel is the chain of connections to the jump hosts of a host used to forward ports
+type tunnel struct {
+	clients []*ssh.Client
+}
+
+func (t *tunnel) last() *ssh.Client {
+	return t.clients[len(t.clients)-1]
+}
+
+func (t *tunnel) Close() {
+	closeClients(t.clients)
+}
+
+// SetJumpHosts sets the chain of jump hosts through which the SSH connections and forwarded ports of the host
+// are tunneled; the first jump host is dialled directly and each following one through the previous one
+func SetJumpHosts(host string, hosts []JumpHost) {
+	jumpLock.Lock()
+	defer jumpLock.Unlock()
+	if len(hosts) == 0 {
+		delete(jumpHosts, host)
+	} else {
+		jumpHosts[host] = hosts
+	}
+	t := tunnels[host]
+	if t != nil {
+		t.Close()
+		delete(tunnels, host)
+	}
+}
+
+func getJumpHosts(host string) []JumpHost {
+	jumpLock.Lock()
+	defer jumpLock.Unlock()
+	return jumpHosts[host]
+}
+
+// ParseJumpHosts parses the comma separated list of jump hosts in the `[user@]host[:port]` format of ProxyJump.
+// The given user, private key and password are used for the jump hosts which do not specify a user
+func ParseJumpHosts(text string, user string, privateKey string, password string) ([]JumpHost, error) {
+	answer := []JumpHost{}
+	text = strings.TrimSpace(text)
+	if len(text) == 0 || strings.ToLower(text) == "none" {
+		return answer, nil
+	}
+	for _, value := range strings.Split(text, ",") {
+		value = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(value), "ssh://"))
+		if len(value) == 0 {
+			continue
+		}
+		jump := JumpHost{User: user, PrivateKey: privateKey, Password: password, Port: defaultSSHPort}
+		i := strings.LastIndex(value, "@")
+		if i >= 0 {
+			jump.User = value[:i]
+			value = value[i+1:]
+		}
+		host, port, err := net.SplitHostPort(value)
+		if err == nil {
+			jump.Host = host
+			jump.Port = port
+		} else {
+			jump.Host = strings.Trim(value, "[]")
+		}
+		if len(jump.Host) == 0 {
+			return nil, fmt.Errorf("Invalid jump host `%s`", value)
+		}
+		answer = append(answer, jump)
+	}
+	return answer, nil
+}
+
+// ParseProxyArgs parses the jump hosts from the ProxyJump (`-J` or `-o ProxyJump=`) or ProxyCommand
+// (`-o ProxyCommand="ssh -W %h:%p bastion"`) options in the SSH arguments such as `ansible_ssh_common_args`.
+// Returns no jump hosts if there are no such options
+func ParseProxyArgs(args string, user string, privateKey string, password string) ([]JumpHost, error) {
+	words, err := splitWords(args)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < len(words); i++ {
+		word := words[i]
+		option := ""
+		switch {
+		case word == "-J" && i+1 < len(words):
+			i++
+			return ParseJumpHosts(words[i], user, privateKey, password)
+		case strings.HasPrefix(word, "-J"):
+			return ParseJumpHosts(word[2:], user, privateKey, password)
+		case word == "-o" && i+1 < len(words):
+			i++
+			option = words[i]
+		case strings.HasPrefix(word, "-o"):
+			option = word[2:]
+		default:
+			continue
+		}
+		name, value := splitOption(option)
+		switch strings.ToLower(name) {
+		case "proxyjump":
+			return ParseJumpHosts(value, user, privateKey, password)
+		case "proxycommand":
+			return parseProxyCommand(value, user, privateKey, password)
+		}
+	}
+	return []JumpHost{}, nil
+}
+
+// parseProxyCommand parses the jump host from a ProxyCommand which uses ssh such as `ssh -W %h:%p -q user@bastion`
+// or `ssh -p 2222 bastion nc %h %p`
+func parseProxyCommand(command string, user string, privateKey string, password string) ([]JumpHost, error) {
+	words, err := splitWords(command)
+	if err != nil {
+		return nil, err
+	}
+	if len(words) == 0 || strings.ToLower(command) == "none" {
+		return []JumpHost{}, nil
+	}
+	if words[0] != "ssh" && !strings.HasSuffix(words[0], "/ssh") {
+		return nil, fmt.Errorf("Unsupported ProxyCommand `%s`; only ProxyCommands using ssh are supported", command)
+	}
+	jumpUser := ""
+	port := ""
+	chain := []JumpHost{}
+	for i := 1; i < len(words); i++ {
+		word := words[i]
+		if word == "-" {
+			continue
+		}
+		if !strings.HasPrefix(word, "-") {
+			jumps, err := ParseJumpHosts(word, user, privateKey, password)
+			if err != nil {
+				return nil, err
+			}
+			jump := jumps[0]
+			if len(jumpUser) > 0 && !strings.Contains(word, "@") {
+				jump.User = jumpUser
+			}
+			if len(port) > 0 {
+				jump.Port = port
+			}
+			return append(chain, jump), nil
+		}
+		value := ""
+		if len(word) > 2 {
+			value = word[2:]
+		} else if strings.ContainsAny(word[1:], "bcDEeFIiJLlmOopQRSWw") && i+1 < len(words) {
+			i++
+			value = words[i]
+		}
+		switch word[:2] {
+		case "-l":
+			jumpUser = value
+		case "-p":
+			port = value
+		case "-J":
+			chain, err = ParseJumpHosts(value, user, privateKey, password)
+			if err != nil {
+				return nil, err
+			}
+		case "-o":
+			name, optionValue := splitOption(value)
+			switch strings.ToLower(name) {
+			case "user":
+				jumpUser = optionValue
+			case "port":
+				port = optionValue
+			case "proxyjump":
+				chain, err = ParseJumpHosts(optionValue, user, privateKey, password)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return nil, fmt.Errorf("Could not find the jump host in the ProxyCommand `%s`", command)
+}
+
+// splitOption splits an SSH option in the `Name=value` or `Name value` format
+func splitOption(option string) (string, string) {
+	i := strings.IndexAny(option, "= ")
+	if i < 0 {
+		return option, ""
+	}
+	return option[:i], strings.TrimSpace(option[i+1:])
+}
+
+// splitWords splits the text into words respecting single and double quotes
+func splitWords(text string) ([]string, error) {
+	words := []string{}
+	var word []rune
+	inWord := false
+	var quote rune
+	for _, r := range text {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word = append(word, r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, string(word))
+				word = nil
+				inWord = false
+			}
+		default:
+			word = append(word, r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("Unterminated quote in `%s`", text)
+	}
+	if inWord {
+		words = append(words, string(word))
+	}
+	return words, nil
+}
+
+// dialJumpHosts connects to each of the jump hosts in turn through the previous one
+func dialJumpHosts(jumps []JumpHost) ([]*ssh.Client, error) {
+	clients := []*ssh.Client{}
+	var via *ssh.Client
+	for _, jump := range jumps {
+		client, err := dialVia(via, jump.User, jump.PrivateKey, jump.Password, jump.Host, jump.Port)
+		if err != nil {
+			closeClients(clients)
+			return nil, fmt.Errorf("Failed to connect to jump host %s: %s", jump, err)
+		}
+		clients = append(clients, client)
+		via = client
+	}
+	return clients, nil
+}
+
+// dialVia opens an SSH connection to the host and port either directly or through the given client of a jump host
+func dialVia(via *ssh.Client, user string, privateKey string, password string, host string, port string) (*ssh.Client, error) {
+	auth, err := newAuthentication(privateKey, password)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot connect to host %s: %s", host, err)
+	}
+	defer auth.Close()
+	hostPort := net.JoinHostPort(host, port)
+
+	sshConfig := &ssh.ClientConfig{
+		User:            user,
+		Auth:            auth.methods,
+		HostKeyCallback: hostKeyCallback(),
+	}
+	var conn net.Conn
+	if via == nil {
+		conn, err = net.DialTimeout("tcp", hostPort, ConnectTimeout)
+		if err == nil {
+			enableTCPKeepAlive(conn)
+		}
+	} else {
+		conn, err = via.Dial("tcp", hostPort)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to dial: %s", err)
+	}
+	timedOut := handshakeTimeout(conn)
+	c, chans, reqs, err := ssh.NewClientConn(conn, hostPort, sshConfig)
+	if timedOut() {
+		if err == nil {
+			c.Close()
+		}
+		return nil, fmt.Errorf("Timed out after %s waiting for the SSH handshake with %s", ConnectTimeout, hostPort)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, authError(err, user, hostPort, auth)
+	}
+	client := ssh.NewClient(c, chans, reqs)
+	go keepAlive(client, hostPort)
+	return client, nil
+}
+
+// DialTunnel opens a TCP connection to the address on a host; if the host has jump hosts the connection is
+// tunneled through them reusing the connections to the jump hosts
+func DialTunnel(address string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	jumps := getJumpHosts(host)
+	if len(jumps) == 0 {
+		return net.DialTimeout("tcp", address, tunnelDialTimeout)
+	}
+	for attempt := 0; ; attempt++ {
+		t, err := getTunnel(host, jumps)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := t.last().Dial("tcp", address)
+		if err == nil || attempt > 0 {
+			return conn, err
+		}
+		// the connections to the jump hosts may have been dropped so lets reconnect and try again
+		jumpLock.Lock()
+		if tunnels[host] == t {
+			delete(tunnels, host)
+		}
+		jumpLock.Unlock()
+		t.Close()
+	}
+}
+
+func getTunnel(host string, jumps []JumpHost) (*tunnel, error) {
+	jumpLock.Lock()
+	t := tunnels[host]
+	jumpLock.Unlock()
+	if t != nil {
+		return t, nil
+	}
+	clients, err := dialJumpHosts(jumps)
+	if err != nil {
+		return nil, err
+	}
+	t = &tunnel{clients: clients}
+	jumpLock.Lock()
+	defer jumpLock.Unlock()
+	if existing := tunnels[host]; existing != nil {
+		t.Close()
+		return existing, nil
+	}
+	tunnels[host] = t
+	return t, nil
+}
+
+func closeClients(clients []*ssh.Client) {
+	for i := len(clients) - 1; i >= 0; i-- {
+		clients[i].Close()
+	}
+}
@@ -0,0 +1,118 @@
+/*
+ * Copyright 2016 Red Hat
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ssh
+
+import (
+	"reflect"
+	"testing"
+)
+
+func jumpHost(user string, host string, port string) JumpHost {
+	return JumpHost{User: user, PrivateKey: "/keys/id_rsa", Password: "secret", Host: host, Port: port}
+}
+
+func TestParseJumpHosts(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected []JumpHost
+	}{
+		{"", []JumpHost{}},
+		{"none", []JumpHost{}},
+		{"bastion", []JumpHost{jumpHost("admin", "bastion", "22")}},
+		{"jump@bastion", []JumpHost{jumpHost("jump", "bastion", "22")}},
+		{"jump@bastion:2222", []JumpHost{jumpHost("jump", "bastion", "2222")}},
+		{"ssh://jump@bastion:2222", []JumpHost{jumpHost("jump", "bastion", "2222")}},
+		{"[fe80::1]:2222", []JumpHost{jumpHost("admin", "fe80::1", "2222")}},
+		{"[fe80::1]", []JumpHost{jumpHost("admin", "fe80::1", "22")}},
+		{"jump@bastion1:2222, bastion2,other@bastion3", []JumpHost{
+			jumpHost("jump", "bastion1", "2222"),
+			jumpHost("admin", "bastion2", "22"),
+			jumpHost("other", "bastion3", "22"),
+		}},
+		{"user@domain@bastion", []JumpHost{jumpHost("user@domain", "bastion", "22")}},
+	}
+	for _, test := range tests {
+		actual, err := ParseJumpHosts(test.text, "admin", "/keys/id_rsa", "secret")
+		if err != nil {
+			t.Errorf("ParseJumpHosts(%q): unexpected error: %s", test.text, err)
+			continue
+		}
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("ParseJumpHosts(%q): expected %v but got %v", test.text, test.expected, actual)
+		}
+	}
+}
+
+func TestParseJumpHostsErrors(t *testing.T) {
+	for _, text := range []string{"jump@", "bastion,:2222"} {
+		_, err := ParseJumpHosts(text, "admin", "/keys/id_rsa", "secret")
+		if err == nil {
+			t.Errorf("ParseJumpHosts(%q): expected an error", text)
+		}
+	}
+}
+
+func TestParseProxyArgs(t *testing.T) {
+	tests := []struct {
+		args     string
+		expected []JumpHost
+	}{
+		{"", []JumpHost{}},
+		{"-o StrictHostKeyChecking=no -C", []JumpHost{}},
+		{"-J jump@bastion:2222", []JumpHost{jumpHost("jump", "bastion", "2222")}},
+		{"-Jbastion1,bastion2", []JumpHost{jumpHost("admin", "bastion1", "22"), jumpHost("admin", "bastion2", "22")}},
+		{"-o ProxyJump=jump@bastion", []JumpHost{jumpHost("jump", "bastion", "22")}},
+		{"-oProxyJump=bastion", []JumpHost{jumpHost("admin", "bastion", "22")}},
+		{"-o 'ProxyJump bastion'", []JumpHost{jumpHost("admin", "bastion", "22")}},
+		{"-o ProxyJump=none", []JumpHost{}},
+		{`-o ProxyCommand="ssh -W %h:%p bastion"`, []JumpHost{jumpHost("admin", "bastion", "22")}},
+		{`-o ProxyCommand="ssh -W %h:%p -q jump@bastion"`, []JumpHost{jumpHost("jump", "bastion", "22")}},
+		{`-o ProxyCommand="ssh -W%h:%p bastion"`, []JumpHost{jumpHost("admin", "bastion", "22")}},
+		{`-o ProxyCommand="/usr/bin/ssh -W %h:%p bastion"`, []JumpHost{jumpHost("admin", "bastion", "22")}},
+		{`-o 'ProxyCommand=ssh -i /keys/other -p 2222 -l jump -W %h:%p bastion'`, []JumpHost{jumpHost("jump", "bastion", "2222")}},
+		{`-o ProxyCommand="ssh -o User=jump -o Port=2222 -o StrictHostKeyChecking=no -W %h:%p bastion"`, []JumpHost{jumpHost("jump", "bastion", "2222")}},
+		{`-o ProxyCommand="ssh -p2222 -ljump bastion nc %h %p"`, []JumpHost{jumpHost("jump", "bastion", "2222")}},
+		{`-o ProxyCommand="ssh -l jump other@bastion -W %h:%p"`, []JumpHost{jumpHost("other", "bastion", "22")}},
+		{`-o ProxyCommand="ssh -J bastion1 -W %h:%p bastion2"`, []JumpHost{jumpHost("admin", "bastion1", "22"), jumpHost("admin", "bastion2", "22")}},
+		{`-o ProxyCommand=none`, []JumpHost{}},
+		{`-C -o ControlMaster=auto -o ProxyCommand="ssh -W %h:%p bastion" -o ControlPersist=60s`, []JumpHost{jumpHost("admin", "bastion", "22")}},
+	}
+	for _, test := range tests {
+		actual, err := ParseProxyArgs(test.args, "admin", "/keys/id_rsa", "secret")
+		if err != nil {
+			t.Errorf("ParseProxyArgs(%q): unexpected error: %s", test.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("ParseProxyArgs(%q): expected %v but got %v", test.args, test.expected, actual)
+		}
+	}
+}
+
+func TestParseProxyArgsErrors(t *testing.T) {
+	tests := []string{
+		`-o ProxyCommand="nc -X connect -x proxy:3128 %h %p"`,
+		`-o ProxyCommand="ssh -W %h:%p"`,
+		`-o ProxyCommand="ssh -W %h:%p bastion`,
+	}
+	for _, args := range tests {
+		_, err := ParseProxyArgs(args, "admin", "/keys/id_rsa", "secret")
+		if err == nil {
+			t.Errorf("ParseProxyArgs(%q): expected an error", args)
+		}
+	}
+}
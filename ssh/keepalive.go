@@ -0,0 +1,153 @@
+/*
+ * Copyright 2016 Red Hat
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ssh
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/fabric8io/kansible/log"
+)
+
+const (
+	// DefaultConnectTimeout is how long to wait for the TCP connection and SSH handshake with a host
+	DefaultConnectTimeout = 30 * time.Second
+
+	// DefaultKeepAliveInterval is how often a keepalive request is sent over the SSH connections
+	DefaultKeepAliveInterval = 15 * time.Second
+
+	// DefaultKeepAliveCountMax is how many keepalive requests can go unanswered before the connection is closed
+	DefaultKeepAliveCountMax = 3
+
+	keepAliveRequest = "keepalive@openssh.com"
+)
+
+var (
+	// ConnectTimeout is how long to wait for the TCP connection and SSH handshake with a host; 0 waits forever
+	ConnectTimeout = DefaultConnectTimeout
+
+	// KeepAliveInterval is how often a keepalive request is sent over the SSH connections and the TCP keepalive
+	// period; 0 disables the keepalive requests
+	KeepAliveInterval = DefaultKeepAliveInterval
+
+	// KeepAliveCountMax is how many keepalive requests in a row can go unanswered before the connection is
+	// considered dead and closed, like the ServerAliveCountMax option of ssh
+	KeepAliveCountMax = DefaultKeepAliveCountMax
+)
+
+// ConfigureKeepAlive configures the connect timeout and keepalives of the SSH connections; blank values use the defaults
+func ConfigureKeepAlive(connectTimeout string, interval string, countMax string) error {
+	if len(connectTimeout) > 0 {
+		duration, err := time.ParseDuration(connectTimeout)
+		if err != nil || duration < 0 {
+			return fmt.Errorf("Invalid connect timeout `%s`", connectTimeout)
+		}
+		ConnectTimeout = duration
+	}
+	if len(interval) > 0 {
+		duration, err := time.ParseDuration(interval)
+		if err != nil || duration < 0 {
+			return fmt.Errorf("Invalid keepalive interval `%s`", interval)
+		}
+		KeepAliveInterval = duration
+	}
+	if len(countMax) > 0 {
+		count, err := strconv.Atoi(countMax)
+		if err != nil || count < 1 {
+			return fmt.Errorf("Invalid keepalive count `%s`; expected a number greater than 0", countMax)
+		}
+		KeepAliveCountMax = count
+	}
+	return nil
+}
+
+// enableTCPKeepAlive enables TCP keepalive on direct connections to hosts so that the kernel notices dropped peers
+func enableTCPKeepAlive(conn net.Conn) {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok || KeepAliveInterval <= 0 {
+		return
+	}
+	tcpConn.SetKeepAlive(true)
+	tcpConn.SetKeepAlivePeriod(KeepAliveInterval)
+}
+
+// handshakeTimeout closes the connection if the SSH handshake has not completed within the connect timeout.
+// The returned function must be called once the handshake completes and returns true if it timed out
+func handshakeTimeout(conn net.Conn) func() bool {
+	if ConnectTimeout <= 0 {
+		return func() bool { return false }
+	}
+	timer := time.AfterFunc(ConnectTimeout, func() {
+		conn.Close()
+	})
+	return func() bool {
+		return !timer.Stop()
+	}
+}
+
+// keepAlive sends keepalive requests over the connection until its closed; closing it if KeepAliveCountMax
+// requests in a row are not answered within the interval so that anything using the connection fails
+// rather than hanging on a dead connection
+func keepAlive(client *ssh.Client, address string) {
+	interval := KeepAliveInterval
+	if interval <= 0 {
+		return
+	}
+	closed := make(chan struct{})
+	go func() {
+		client.Wait()
+		close(closed)
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	missed := 0
+	for {
+		select {
+		case <-closed:
+			return
+		case <-ticker.C:
+		}
+		replies := make(chan error, 1)
+		go func() {
+			// any reply, even a failure because the server does not know the request, means its alive
+			_, _, err := client.SendRequest(keepAliveRequest, true, nil)
+			replies <- err
+		}()
+		select {
+		case <-closed:
+			return
+		case err := <-replies:
+			if err != nil {
+				return
+			}
+			missed = 0
+		case <-time.After(interval):
+			missed++
+			log.Debug("No reply to keepalive %d of %d from %s", missed, KeepAliveCountMax, address)
+			if missed >= KeepAliveCountMax {
+				log.Warn("Closing the SSH connection to %s as it did not reply to %d keepalives", address, missed)
+				client.Close()
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,461 @@
+/*
+ * Copyright 2016 Red Hat
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ssh
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fabric8io/kansible/log"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// HostKeyCheckingStrict only accepts host keys which are in the known hosts
+	HostKeyCheckingStrict = "strict"
+
+	// HostKeyCheckingTrustOnFirstUse accepts and records the host key of hosts which are not in the known hosts
+	// but still rejects host keys which do not match the known hosts
+	HostKeyCheckingTrustOnFirstUse = "tofu"
+
+	// HostKeyCheckingOff accepts any host key
+	HostKeyCheckingOff = "off"
+
+	markerCertAuthority = "@cert-authority"
+	markerRevoked       = "@revoked"
+
+	hashedHostPrefix = "|1|"
+	defaultSSHPort   = "22"
+	scanTimeout      = 10 * time.Second
+)
+
+// knownHostLine is a parsed line of a known_hosts file
+type knownHostLine struct {
+	marker   string
+	patterns []string
+	key      ssh.PublicKey
+	text     string
+}
+
+// KnownHosts are the host keys of a known_hosts file used to verify the host keys of SSH connections
+type KnownHosts struct {
+	lock  sync.Mutex
+	lines []knownHostLine
+}
+
+// LoadKnownHosts loads the known hosts from the given known_hosts file; if the file does not exist there are no
+// known hosts
+func LoadKnownHosts(file string) (*KnownHosts, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &KnownHosts{}, nil
+		}
+		return nil, fmt.Errorf("Failed to read known hosts file %s: %s", file, err)
+	}
+	knownHosts, err := ParseKnownHosts(data)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse known hosts file %s: %s", file, err)
+	}
+	return knownHosts, nil
+}
+
+// ParseKnownHosts parses the known hosts in the known_hosts format
+func ParseKnownHosts(data []byte) (*KnownHosts, error) {
+	knownHosts := &KnownHosts{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		text := strings.TrimSpace(scanner.Text())
+		if len(text) == 0 || strings.HasPrefix(text, "#") {
+			continue
+		}
+		line, err := parseKnownHostLine(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", lineNumber, err)
+		}
+		knownHosts.lines = append(knownHosts.lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return knownHosts, nil
+}
+
+func parseKnownHostLine(text string) (knownHostLine, error) {
+	line := knownHostLine{text: text}
+	rest := text
+	if strings.HasPrefix(rest, "@") {
+		fields := strings.Fields(rest)
+		line.marker = fields[0]
+		if line.marker != markerCertAuthority && line.marker != markerRevoked {
+			return line, fmt.Errorf("Unknown marker %s", line.marker)
+		}
+		rest = strings.TrimSpace(rest[len(line.marker):])
+	}
+	fields := strings.Fields(rest)
+	if len(fields) < 3 {
+		return line, fmt.Errorf("Expected the host names, key type and key")
+	}
+	line.patterns = strings.Split(fields[0], ",")
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(strings.TrimSpace(rest[len(fields[0]):])))
+	if err != nil {
+		return line, fmt.Errorf("Invalid key for %s: %s", fields[0], err)
+	}
+	line.key = key
+	return line, nil
+}
+
+// Add adds the known_hosts line to the known hosts
+func (k *KnownHosts) Add(text string) error {
+	line, err := parseKnownHostLine(strings.TrimSpace(text))
+	if err != nil {
+		return err
+	}
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	k.lines = append(k.lines, line)
+	return nil
+}
+
+// Lines returns the known_hosts lines which apply to the given host and port including any certificate
+// authorities and revoked keys
+func (k *KnownHosts) Lines(host string, port string) []string {
+	address := KnownHostAddress(host, port)
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	answer := []string{}
+	for _, line := range k.lines {
+		if line.matches(address) {
+			answer = append(answer, line.text)
+		}
+	}
+	return answer
+}
+
+// HostKeyCallback returns the callback which verifies the host keys of SSH connections using the given mode.
+// In trust on first use mode the host keys of unknown hosts are added to the known hosts and passed to the
+// record function so that they can be persisted
+func (k *KnownHosts) HostKeyCallback(mode string, record func(line string) error) func(string, net.Addr, ssh.PublicKey) error {
+	return func(hostPort string, remote net.Addr, key ssh.PublicKey) error {
+		if mode == HostKeyCheckingOff {
+			return nil
+		}
+		host, port, err := net.SplitHostPort(hostPort)
+		if err != nil {
+			host = hostPort
+			port = defaultSSHPort
+		}
+		address := KnownHostAddress(host, port)
+
+		k.lock.Lock()
+		lines := make([]knownHostLine, len(k.lines))
+		copy(lines, k.lines)
+		k.lock.Unlock()
+
+		plainKey := key
+		cert, isCert := key.(*ssh.Certificate)
+		if isCert {
+			plainKey = cert.Key
+		}
+		for _, line := range lines {
+			if line.marker == markerRevoked && (keysEqual(line.key, key) || keysEqual(line.key, plainKey)) {
+				return fmt.Errorf("The host key %s of %s has been revoked", Fingerprint(key), address)
+			}
+		}
+
+		if isCert && cert.CertType == ssh.HostCert {
+			checker := &ssh.CertChecker{
+				IsAuthority: func(authority ssh.PublicKey) bool {
+					for _, line := range lines {
+						if line.marker == markerCertAuthority && keysEqual(line.key, authority) && line.matches(address) {
+							return true
+						}
+					}
+					return false
+				},
+			}
+			err := checker.CheckCert(host, cert)
+			if err == nil {
+				return nil
+			}
+			log.Debug("The host certificate of %s could not be verified by a certificate authority so checking its key: %s", address, err)
+		}
+
+		known := false
+		for _, line := range lines {
+			if len(line.marker) > 0 || !line.matches(address) {
+				continue
+			}
+			if keysEqual(line.key, key) || keysEqual(line.key, plainKey) {
+				return nil
+			}
+			known = true
+		}
+		if known {
+			return fmt.Errorf("The host key %s of %s does not match the known hosts; someone could be eavesdropping or the host key has been changed", Fingerprint(key), address)
+		}
+		if mode != HostKeyCheckingTrustOnFirstUse {
+			return fmt.Errorf("The host key %s of %s is not in the known hosts; add it via `kansible rc --scan-host-keys` or use %s host key checking", Fingerprint(key), address, HostKeyCheckingTrustOnFirstUse)
+		}
+
+		text := KnownHostsLine(host, port, plainKey)
+		err = k.Add(text)
+		if err != nil {
+			return err
+		}
+		log.Warn("Trusting the host key %s of %s on first use", Fingerprint(plainKey), address)
+		if record != nil {
+			err = record(text)
+			if err != nil {
+				log.Warn("Failed to record the host key of %s: %s", address, err)
+			}
+		}
+		return nil
+	}
+}
+
+// matches returns true if the patterns of the line match the address and none of its negated patterns do
+func (line *knownHostLine) matches(address string) bool {
+	matched := false
+	for _, pattern := range line.patterns {
+		if strings.HasPrefix(pattern, "!") {
+			if matchHostPattern(pattern[1:], address) {
+				return false
+			}
+		} else if matchHostPattern(pattern, address) {
+			matched = true
+		}
+	}
+	return matched
+}
+
+func matchHostPattern(pattern string, address string) bool {
+	if strings.HasPrefix(pattern, hashedHostPrefix) {
+		parts := strings.Split(pattern[len(hashedHostPrefix):], "|")
+		if len(parts) != 2 {
+			return false
+		}
+		salt, err := base64.StdEncoding.DecodeString(parts[0])
+		if err != nil {
+			return false
+		}
+		hash, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			return false
+		}
+		mac := hmac.New(sha1.New, salt)
+		mac.Write([]byte(address))
+		return hmac.Equal(mac.Sum(nil), hash)
+	}
+	return matchWildcard(strings.ToLower(pattern), strings.ToLower(address))
+}
+
+// matchWildcard matches the text against a pattern where `*` matches any characters and `?` matches one character
+func matchWildcard(pattern string, text string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(text); i >= 0; i-- {
+				if matchWildcard(pattern[1:], text[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(text) == 0 {
+				return false
+			}
+		default:
+			if len(text) == 0 || pattern[0] != text[0] {
+				return false
+			}
+		}
+		pattern = pattern[1:]
+		text = text[1:]
+	}
+	return len(text) == 0
+}
+
+func keysEqual(a ssh.PublicKey, b ssh.PublicKey) bool {
+	return a.Type() == b.Type() && bytes.Equal(a.Marshal(), b.Marshal())
+}
+
+// KnownHostAddress returns the host name used in known_hosts for the given host and port
+func KnownHostAddress(host string, port string) string {
+	if len(port) == 0 || port == defaultSSHPort {
+		return host
+	}
+	return "[" + host + "]:" + port
+}
+
+// KnownHostsLine returns the known_hosts line for the host key of the given host and port
+func KnownHostsLine(host string, port string, key ssh.PublicKey) string {
+	return KnownHostAddress(host, port) + " " + strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+}
+
+// Fingerprint returns the SHA256 fingerprint of the key in the format used by OpenSSH
+func Fingerprint(key ssh.PublicKey) string {
+	hash := sha256.Sum256(key.Marshal())
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(hash[:])
+}
+
+var errHostKeyScanned = errors.New("host key scanned")
+
+// ScanHostKey returns the host key of the given host and port without authenticating. If there are jump hosts the
+// host is scanned through them like the SSH connections of the pods; the host keys of the jump hosts themselves are
+// verified as configured by ConfigureHostKeyChecking
+func ScanHostKey(host string, port string, jumps []JumpHost) (ssh.PublicKey, error) {
+	if len(port) == 0 {
+		port = defaultSSHPort
+	}
+	hostPort := net.JoinHostPort(host, port)
+	var conn net.Conn
+	var err error
+	if len(jumps) == 0 {
+		conn, err = net.DialTimeout("tcp", hostPort, scanTimeout)
+	} else {
+		var clients []*ssh.Client
+		clients, err = dialJumpHosts(jumps)
+		if err == nil {
+			defer closeClients(clients)
+			conn, err = clients[len(clients)-1].Dial("tcp", hostPort)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to connect to %s to scan its host key: %s", hostPort, err)
+	}
+	defer conn.Close()
+	timer := time.AfterFunc(scanTimeout, func() {
+		conn.Close()
+	})
+	defer timer.Stop()
+
+	var hostKey ssh.PublicKey
+	config := &ssh.ClientConfig{
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			hostKey = key
+			return errHostKeyScanned
+		},
+	}
+	_, _, _, err = ssh.NewClientConn(conn, hostPort, config)
+	if hostKey == nil {
+		return nil, fmt.Errorf("Failed to scan the host key of %s: %s", hostPort, err)
+	}
+	if cert, ok := hostKey.(*ssh.Certificate); ok {
+		return cert.Key, nil
+	}
+	return hostKey, nil
+}
+
+var (
+	hostKeyMode   = HostKeyCheckingTrustOnFirstUse
+	knownHosts    = &KnownHosts{}
+	recordLock    sync.Mutex
+	recordHostKey func(line string) error
+)
+
+// DefaultKnownHostsFile returns the known_hosts file of the current user
+func DefaultKnownHostsFile() string {
+	return filepath.Join(os.Getenv("HOME"), ".ssh", "known_hosts")
+}
+
+// ConfigureHostKeyChecking configures how the host keys of SSH connections are verified using the given mode and
+// known_hosts file; if the file is blank the known_hosts file of the current user is used. If the mode is blank host
+// keys are verified strictly if the file exists, such as the known hosts Secret mounted in the pods, otherwise they
+// are trusted on first use. Host keys trusted on first use are appended to the file unless RecordNewHostKeys is used
+func ConfigureHostKeyChecking(mode string, file string) error {
+	mode = strings.ToLower(strings.TrimSpace(mode))
+	switch mode {
+	case "", HostKeyCheckingStrict, HostKeyCheckingTrustOnFirstUse, HostKeyCheckingOff:
+	default:
+		return fmt.Errorf("Unknown host key checking mode `%s`; expected one of %s, %s, %s", mode, HostKeyCheckingStrict, HostKeyCheckingTrustOnFirstUse, HostKeyCheckingOff)
+	}
+	if len(file) == 0 {
+		file = DefaultKnownHostsFile()
+	}
+	if len(mode) == 0 {
+		mode = HostKeyCheckingTrustOnFirstUse
+		if _, err := os.Stat(file); err == nil {
+			mode = HostKeyCheckingStrict
+		}
+	}
+	loaded, err := LoadKnownHosts(file)
+	if err != nil {
+		return err
+	}
+	hostKeyMode = mode
+	knownHosts = loaded
+	RecordNewHostKeys(func(line string) error {
+		return appendKnownHostsLine(file, line)
+	})
+	return nil
+}
+
+// TrustHostKey adds the known_hosts line to the known hosts used to verify the SSH connections of this process
+// without persisting it; such as for a host key which has just been scanned
+func TrustHostKey(line string) error {
+	return knownHosts.Add(line)
+}
+
+// RecordNewHostKeys sets the function which persists the known_hosts lines of the host keys trusted on first use
+func RecordNewHostKeys(record func(line string) error) {
+	recordLock.Lock()
+	defer recordLock.Unlock()
+	recordHostKey = record
+}
+
+// hostKeyCallback returns the callback which verifies host keys as configured by ConfigureHostKeyChecking
+func hostKeyCallback() func(string, net.Addr, ssh.PublicKey) error {
+	return knownHosts.HostKeyCallback(hostKeyMode, func(line string) error {
+		recordLock.Lock()
+		record := recordHostKey
+		recordLock.Unlock()
+		if record == nil {
+			return nil
+		}
+		return record(line)
+	})
+}
+
+func appendKnownHostsLine(file string, line string) error {
+	err := os.MkdirAll(filepath.Dir(file), 0700)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.WriteString(line + "\n")
+	return err
+}
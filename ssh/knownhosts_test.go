@@ -0,0 +1,420 @@
+/*
+ * Copyright 2016 Red Hat
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ssh
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestSigner(t *testing.T) ssh.Signer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %s", err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatalf("Failed to create signer: %s", err)
+	}
+	return signer
+}
+
+func newTestHostCert(t *testing.T, key ssh.PublicKey, authority ssh.Signer, principal string) *ssh.Certificate {
+	cert := &ssh.Certificate{
+		Key:             key,
+		CertType:        ssh.HostCert,
+		ValidPrincipals: []string{principal},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	err := cert.SignCert(rand.Reader, authority)
+	if err != nil {
+		t.Fatalf("Failed to sign certificate: %s", err)
+	}
+	return cert
+}
+
+// hashHost hashes the host name like `ssh-keygen -H`
+func hashHost(address string) string {
+	salt := []byte("0123456789abcdefghij")
+	mac := hmac.New(sha1.New, salt)
+	mac.Write([]byte(address))
+	return hashedHostPrefix + base64.StdEncoding.EncodeToString(salt) + "|" + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func authorizedKey(key ssh.PublicKey) string {
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+}
+
+func TestHostKeyCallback(t *testing.T) {
+	hostKey := newTestSigner(t).PublicKey()
+	otherKey := newTestSigner(t).PublicKey()
+	authority := newTestSigner(t)
+	cert := newTestHostCert(t, hostKey, authority, "app1.example.com")
+
+	tests := []struct {
+		name       string
+		knownHosts []string
+		hostPort   string
+		key        ssh.PublicKey
+		mode       string
+		valid      bool
+	}{
+		{
+			name:       "plain host",
+			knownHosts: []string{"app1.example.com " + authorizedKey(hostKey)},
+			hostPort:   "app1.example.com:22",
+			key:        hostKey,
+			valid:      true,
+		},
+		{
+			name:       "host names are not case sensitive",
+			knownHosts: []string{"APP1.example.com " + authorizedKey(hostKey)},
+			hostPort:   "app1.example.com:22",
+			key:        hostKey,
+			valid:      true,
+		},
+		{
+			name:       "changed host key",
+			knownHosts: []string{"app1.example.com " + authorizedKey(otherKey)},
+			hostPort:   "app1.example.com:22",
+			key:        hostKey,
+		},
+		{
+			name:       "changed host key is rejected on first use too",
+			knownHosts: []string{"app1.example.com " + authorizedKey(otherKey)},
+			hostPort:   "app1.example.com:22",
+			key:        hostKey,
+			mode:       HostKeyCheckingTrustOnFirstUse,
+		},
+		{
+			name:     "unknown host",
+			hostPort: "app1.example.com:22",
+			key:      hostKey,
+		},
+		{
+			name:     "unknown host trusted on first use",
+			hostPort: "app1.example.com:22",
+			key:      hostKey,
+			mode:     HostKeyCheckingTrustOnFirstUse,
+			valid:    true,
+		},
+		{
+			name:       "any host key when checking is off",
+			knownHosts: []string{"app1.example.com " + authorizedKey(otherKey)},
+			hostPort:   "app1.example.com:22",
+			key:        hostKey,
+			mode:       HostKeyCheckingOff,
+			valid:      true,
+		},
+		{
+			name:       "one of several host names",
+			knownHosts: []string{"app2.example.com,app1.example.com,10.0.0.1 " + authorizedKey(hostKey)},
+			hostPort:   "10.0.0.1:22",
+			key:        hostKey,
+			valid:      true,
+		},
+		{
+			name:       "hashed host",
+			knownHosts: []string{hashHost("app1.example.com") + " " + authorizedKey(hostKey)},
+			hostPort:   "app1.example.com:22",
+			key:        hostKey,
+			valid:      true,
+		},
+		{
+			name:       "hashed host with a changed host key",
+			knownHosts: []string{hashHost("app1.example.com") + " " + authorizedKey(otherKey)},
+			hostPort:   "app1.example.com:22",
+			key:        hostKey,
+		},
+		{
+			name:       "hashed entry for a different host",
+			knownHosts: []string{hashHost("app2.example.com") + " " + authorizedKey(hostKey)},
+			hostPort:   "app1.example.com:22",
+			key:        hostKey,
+		},
+		{
+			name:       "hashed host and port",
+			knownHosts: []string{hashHost("[app1.example.com]:2222") + " " + authorizedKey(hostKey)},
+			hostPort:   "app1.example.com:2222",
+			key:        hostKey,
+			valid:      true,
+		},
+		{
+			name:       "host and port",
+			knownHosts: []string{"[app1.example.com]:2222 " + authorizedKey(hostKey)},
+			hostPort:   "app1.example.com:2222",
+			key:        hostKey,
+			valid:      true,
+		},
+		{
+			name:       "host without the port does not match a different port",
+			knownHosts: []string{"app1.example.com " + authorizedKey(hostKey)},
+			hostPort:   "app1.example.com:2222",
+			key:        hostKey,
+		},
+		{
+			name:       "host and port does not match the default port",
+			knownHosts: []string{"[app1.example.com]:2222 " + authorizedKey(hostKey)},
+			hostPort:   "app1.example.com:22",
+			key:        hostKey,
+		},
+		{
+			name:       "wildcard",
+			knownHosts: []string{"*.example.com " + authorizedKey(hostKey)},
+			hostPort:   "app1.example.com:22",
+			key:        hostKey,
+			valid:      true,
+		},
+		{
+			name:       "negated pattern",
+			knownHosts: []string{"*.example.com,!app1.example.com " + authorizedKey(hostKey)},
+			hostPort:   "app1.example.com:22",
+			key:        hostKey,
+		},
+		{
+			name:       "certificate authority",
+			knownHosts: []string{markerCertAuthority + " *.example.com " + authorizedKey(authority.PublicKey())},
+			hostPort:   "app1.example.com:22",
+			key:        cert,
+			valid:      true,
+		},
+		{
+			name:       "certificate authority for other hosts",
+			knownHosts: []string{markerCertAuthority + " *.example.org " + authorizedKey(authority.PublicKey())},
+			hostPort:   "app1.example.com:22",
+			key:        cert,
+		},
+		{
+			name:       "certificate for a different principal",
+			knownHosts: []string{markerCertAuthority + " *.example.com " + authorizedKey(authority.PublicKey())},
+			hostPort:   "app2.example.com:22",
+			key:        cert,
+		},
+		{
+			name:       "certificate with a known host key",
+			knownHosts: []string{"app1.example.com " + authorizedKey(hostKey)},
+			hostPort:   "app1.example.com:22",
+			key:        cert,
+			valid:      true,
+		},
+		{
+			name: "revoked host key",
+			knownHosts: []string{
+				"app1.example.com " + authorizedKey(hostKey),
+				markerRevoked + " * " + authorizedKey(hostKey),
+			},
+			hostPort: "app1.example.com:22",
+			key:      hostKey,
+		},
+		{
+			name:       "revoked host key is rejected when checking on first use",
+			knownHosts: []string{markerRevoked + " * " + authorizedKey(hostKey)},
+			hostPort:   "app1.example.com:22",
+			key:        hostKey,
+			mode:       HostKeyCheckingTrustOnFirstUse,
+		},
+		{
+			name: "revoked key of a certificate",
+			knownHosts: []string{
+				markerCertAuthority + " *.example.com " + authorizedKey(authority.PublicKey()),
+				markerRevoked + " * " + authorizedKey(hostKey),
+			},
+			hostPort: "app1.example.com:22",
+			key:      cert,
+		},
+		{
+			name: "other revoked keys",
+			knownHosts: []string{
+				"app1.example.com " + authorizedKey(hostKey),
+				markerRevoked + " * " + authorizedKey(otherKey),
+			},
+			hostPort: "app1.example.com:22",
+			key:      hostKey,
+			valid:    true,
+		},
+	}
+
+	for _, test := range tests {
+		knownHosts, err := ParseKnownHosts([]byte(strings.Join(test.knownHosts, "\n")))
+		if err != nil {
+			t.Fatalf("%s: failed to parse the known hosts: %s", test.name, err)
+		}
+		mode := test.mode
+		if len(mode) == 0 {
+			mode = HostKeyCheckingStrict
+		}
+		err = knownHosts.HostKeyCallback(mode, nil)(test.hostPort, nil, test.key)
+		if test.valid && err != nil {
+			t.Errorf("%s: expected the host key to be accepted but got: %s", test.name, err)
+		} else if !test.valid && err == nil {
+			t.Errorf("%s: expected the host key to be rejected", test.name)
+		}
+	}
+}
+
+func TestHostKeyCallbackRecordsHostKeysTrustedOnFirstUse(t *testing.T) {
+	hostKey := newTestSigner(t).PublicKey()
+	knownHosts := &KnownHosts{}
+	recorded := []string{}
+	callback := knownHosts.HostKeyCallback(HostKeyCheckingTrustOnFirstUse, func(line string) error {
+		recorded = append(recorded, line)
+		return nil
+	})
+
+	err := callback("app1.example.com:2222", nil, hostKey)
+	if err != nil {
+		t.Fatalf("Expected the host key to be trusted on first use but got: %s", err)
+	}
+	expected := "[app1.example.com]:2222 " + authorizedKey(hostKey)
+	if len(recorded) != 1 || recorded[0] != expected {
+		t.Fatalf("Expected the line %q to be recorded but got %q", expected, recorded)
+	}
+
+	err = callback("app1.example.com:2222", nil, newTestSigner(t).PublicKey())
+	if err == nil {
+		t.Errorf("Expected a different host key to be rejected once the first one is trusted")
+	}
+	if len(recorded) != 1 {
+		t.Errorf("Expected no more lines to be recorded but got %q", recorded)
+	}
+}
+
+func TestKnownHostsLines(t *testing.T) {
+	key := authorizedKey(newTestSigner(t).PublicKey())
+	lines := []string{
+		"app1.example.com " + key,
+		"[app1.example.com]:2222 " + key,
+		hashHost("app2.example.com") + " " + key,
+		markerCertAuthority + " *.example.com " + key,
+		markerRevoked + " * " + key,
+	}
+	knownHosts, err := ParseKnownHosts([]byte("# comment\n\n" + strings.Join(lines, "\n")))
+	if err != nil {
+		t.Fatalf("Failed to parse the known hosts: %s", err)
+	}
+
+	tests := []struct {
+		host     string
+		port     string
+		expected []string
+	}{
+		{"app1.example.com", "22", []string{lines[0], lines[3], lines[4]}},
+		{"app1.example.com", "", []string{lines[0], lines[3], lines[4]}},
+		{"app1.example.com", "2222", []string{lines[1], lines[4]}},
+		{"app2.example.com", "22", []string{lines[2], lines[3], lines[4]}},
+		{"app3.example.org", "22", []string{lines[4]}},
+	}
+	for _, test := range tests {
+		actual := knownHosts.Lines(test.host, test.port)
+		if strings.Join(actual, "\n") != strings.Join(test.expected, "\n") {
+			t.Errorf("Lines(%s, %s): expected %q but got %q", test.host, test.port, test.expected, actual)
+		}
+	}
+}
+
+func TestParseKnownHostsErrors(t *testing.T) {
+	key := authorizedKey(newTestSigner(t).PublicKey())
+	tests := []string{
+		"@unknown app1.example.com " + key,
+		"app1.example.com",
+		"app1.example.com ecdsa-sha2-nistp256 notbase64!",
+	}
+	for _, text := range tests {
+		_, err := ParseKnownHosts([]byte(text))
+		if err == nil {
+			t.Errorf("Expected an error parsing %q", text)
+		}
+	}
+}
+
+func TestMatchWildcard(t *testing.T) {
+	tests := []struct {
+		pattern string
+		text    string
+		matches bool
+	}{
+		{"app1.example.com", "app1.example.com", true},
+		{"app1.example.com", "app2.example.com", false},
+		{"*", "anything", true},
+		{"*.example.com", "app1.example.com", true},
+		{"*.example.com", "example.com", false},
+		{"app?.example.com", "app1.example.com", true},
+		{"app?.example.com", "app10.example.com", false},
+		{"10.0.0.*", "10.0.0.15", true},
+		{"[app1.example.com]:*", "[app1.example.com]:2222", true},
+	}
+	for _, test := range tests {
+		if matchWildcard(test.pattern, test.text) != test.matches {
+			t.Errorf("matchWildcard(%q, %q): expected %v", test.pattern, test.text, test.matches)
+		}
+	}
+}
+
+func TestConfigureHostKeyCheckingDefaultMode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kansible-known-hosts")
+	if err != nil {
+		t.Fatalf("Failed to create a temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	existing := filepath.Join(dir, "known_hosts")
+	err = ioutil.WriteFile(existing, []byte("app1.example.com "+authorizedKey(newTestSigner(t).PublicKey())+"\n"), 0600)
+	if err != nil {
+		t.Fatalf("Failed to write the known hosts: %s", err)
+	}
+	missing := filepath.Join(dir, "missing")
+	defer func(mode string, hosts *KnownHosts) {
+		hostKeyMode = mode
+		knownHosts = hosts
+		RecordNewHostKeys(nil)
+	}(hostKeyMode, knownHosts)
+
+	tests := []struct {
+		mode     string
+		file     string
+		expected string
+	}{
+		{"", existing, HostKeyCheckingStrict},
+		{"", missing, HostKeyCheckingTrustOnFirstUse},
+		{"TOFU", existing, HostKeyCheckingTrustOnFirstUse},
+		{"strict", missing, HostKeyCheckingStrict},
+		{"off", existing, HostKeyCheckingOff},
+	}
+	for _, test := range tests {
+		err := ConfigureHostKeyChecking(test.mode, test.file)
+		if err != nil {
+			t.Errorf("ConfigureHostKeyChecking(%q, %s): unexpected error: %s", test.mode, test.file, err)
+			continue
+		}
+		if hostKeyMode != test.expected {
+			t.Errorf("ConfigureHostKeyChecking(%q, %s): expected the mode %s but got %s", test.mode, test.file, test.expected, hostKeyMode)
+		}
+	}
+	if ConfigureHostKeyChecking("lax", existing) == nil {
+		t.Errorf("Expected an error for an unknown host key checking mode")
+	}
+}
@@ -0,0 +1,96 @@
+/*
+ * Copyright 2016 Red Hat
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ssh
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/fabric8io/kansible/log"
+)
+
+const (
+	defaultTerm       = "xterm"
+	defaultTermWidth  = 80
+	defaultTermHeight = 24
+)
+
+// windowChangeMsg is the payload of the window-change request of RFC 4254 Section 6.7
+type windowChangeMsg struct {
+	Columns uint32
+	Rows    uint32
+	Width   uint32
+	Height  uint32
+}
+
+// requestPty requests a pseudo terminal for the session. If stdin is a terminal, such as when a shell is opened
+// via the bash script of the pod, the pseudo terminal has the size of the local terminal and is resized along
+// with it, and the local terminal is put in raw mode so that keys such as Ctrl-C are handled remotely.
+// The returned function restores the local terminal
+func requestPty(session *ssh.Session, logger *log.Logger) (func(), error) {
+	term := os.Getenv("TERM")
+	if len(term) == 0 {
+		term = defaultTerm
+	}
+	fd := int(os.Stdin.Fd())
+	width, height, isTerminal := terminalSize(fd)
+	if !isTerminal {
+		width, height = defaultTermWidth, defaultTermHeight
+	}
+	modes := ssh.TerminalModes{
+		ssh.ECHO:          1,
+		ssh.TTY_OP_ISPEED: 14400, // input speed = 14.4kbaud
+		ssh.TTY_OP_OSPEED: 14400, // output speed = 14.4kbaud
+	}
+	if err := session.RequestPty(term, height, width, modes); err != nil {
+		return nil, fmt.Errorf("Request for pseudo terminal failed: %s", err)
+	}
+	if !isTerminal {
+		return func() {}, nil
+	}
+
+	restore, err := makeRaw(fd)
+	if err != nil {
+		logger.Warn("Failed to put the terminal in raw mode: %s", err)
+		restore = func() {}
+	}
+	resizes := make(chan os.Signal, 1)
+	notifyResize(resizes)
+	go func() {
+		for range resizes {
+			width, height, ok := terminalSize(fd)
+			if !ok {
+				continue
+			}
+			_, err := session.SendRequest("window-change", false, ssh.Marshal(&windowChangeMsg{
+				Columns: uint32(width),
+				Rows:    uint32(height),
+			}))
+			if err != nil {
+				logger.Debug("Failed to resize the pseudo terminal: %s", err)
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(resizes)
+		close(resizes)
+		restore()
+	}, nil
+}
@@ -0,0 +1,38 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+/*
+ * Copyright 2016 Red Hat
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ssh
+
+import (
+	"fmt"
+	"os"
+)
+
+// terminalSize always returns false as the size of the terminal is not supported on this platform
+func terminalSize(fd int) (int, int, bool) {
+	return 0, 0, false
+}
+
+func makeRaw(fd int) (func(), error) {
+	return nil, fmt.Errorf("Raw terminals are not supported on this platform")
+}
+
+// notifyResize does nothing as resizing the terminal is not supported on this platform
+func notifyResize(c chan os.Signal) {
+}
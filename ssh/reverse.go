@@ -0,0 +1,181 @@
+/*
+ * Copyright 2016 Red Hat
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ssh
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/fabric8io/kansible/log"
+)
+
+const defaultReverseBindAddress = "127.0.0.1"
+
+// ReverseForward is a port listened on by the host which is forwarded to an address reachable from the pod
+// such as a Kubernetes service
+type ReverseForward struct {
+	RemoteAddress string
+	LocalAddress  string
+}
+
+func (f ReverseForward) String() string {
+	return f.RemoteAddress + " => " + f.LocalAddress
+}
+
+// ParseReverseForwards parses the space or comma separated reverse forwards in the
+// `[bindAddress:]remotePort:host:port` format; e.g. `5432:postgres:5432`. The remote port is bound to the
+// loopback interface of the host unless a bind address is specified
+func ParseReverseForwards(text string) ([]ReverseForward, error) {
+	answer := []ReverseForward{}
+	for _, value := range strings.FieldsFunc(text, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' || r == '\n' }) {
+		parts := strings.Split(value, ":")
+		bindAddress := defaultReverseBindAddress
+		switch len(parts) {
+		case 3:
+		case 4:
+			bindAddress = parts[0]
+			parts = parts[1:]
+		default:
+			return nil, fmt.Errorf("Invalid reverse port `%s`; expected [bindAddress:]remotePort:host:port", value)
+		}
+		for _, port := range []string{parts[0], parts[2]} {
+			n, err := strconv.Atoi(port)
+			if err != nil || n <= 0 || n > 65535 {
+				return nil, fmt.Errorf("Invalid port `%s` in reverse port `%s`", port, value)
+			}
+		}
+		if len(parts[1]) == 0 {
+			return nil, fmt.Errorf("Missing host in reverse port `%s`", value)
+		}
+		answer = append(answer, ReverseForward{
+			RemoteAddress: net.JoinHostPort(bindAddress, parts[0]),
+			LocalAddress:  net.JoinHostPort(parts[1], parts[2]),
+		})
+	}
+	return answer, nil
+}
+
+// ReverseForwarder listens on ports of a host over SSH, like `ssh -R`, and relays the connections to addresses
+// reachable from the pod so that the remote process can use services inside the cluster
+type ReverseForwarder struct {
+	User       string
+	PrivateKey string
+	Password   string
+	Host       string
+	Port       string
+	Forwards   []ReverseForward
+
+	logger *log.Logger
+}
+
+// Run opens the remote listeners and relays their connections forever; reconnecting with a backoff if the
+// connection to the host fails
+func (r *ReverseForwarder) Run() {
+	r.logger = log.WithFields(log.Fields{log.FieldAddress: r.Host, log.FieldConnection: "ssh"})
+	backoff := time.Second
+	for {
+		client, err := Dial(r.User, r.PrivateKey, r.Password, r.Host, r.Port)
+		if err != nil {
+			r.logger.Warn("Failed to connect to host %s for the reverse ports, retrying in %s: %s", r.Host, backoff, err)
+			time.Sleep(backoff)
+			backoff *= 2
+			if backoff > maxReconnectBackoff {
+				backoff = maxReconnectBackoff
+			}
+			continue
+		}
+		backoff = time.Second
+		closed := make(chan struct{})
+		go r.listen(client, r.Forwards, closed)
+		err = client.Wait()
+		close(closed)
+		r.logger.Warn("The connection to host %s for the reverse ports was closed, reconnecting: %v", r.Host, err)
+		client.Close()
+	}
+}
+
+// listen opens the remote listeners of the forwards; retrying the ones which fail, such as when the remote port is
+// still in use, with a backoff until they are all listening or the connection is closed
+func (r *ReverseForwarder) listen(client *ssh.Client, forwards []ReverseForward, closed <-chan struct{}) {
+	backoff := time.Second
+	for {
+		failed := []ReverseForward{}
+		for _, forward := range forwards {
+			listener, err := client.Listen("tcp", forward.RemoteAddress)
+			if err != nil {
+				r.logger.Err("Failed to listen on %s on host %s for reverse port %s, retrying in %s: %s", forward.RemoteAddress, r.Host, forward, backoff, err)
+				failed = append(failed, forward)
+				continue
+			}
+			r.logger.Info("Forwarding reverse port %s", forward)
+			go r.accept(listener, forward)
+		}
+		if len(failed) == 0 {
+			return
+		}
+		select {
+		case <-closed:
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxReconnectBackoff {
+			backoff = maxReconnectBackoff
+		}
+		forwards = failed
+	}
+}
+
+// accept relays the connections accepted by the remote listener until the connection to the host is closed
+func (r *ReverseForwarder) accept(listener net.Listener, forward ReverseForward) {
+	defer listener.Close()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if err != io.EOF {
+				r.logger.Debug("Stopped accepting connections for reverse port %s: %s", forward, err)
+			}
+			return
+		}
+		go r.relay(conn, forward)
+	}
+}
+
+func (r *ReverseForwarder) relay(conn net.Conn, forward ReverseForward) {
+	local, err := net.DialTimeout("tcp", forward.LocalAddress, tunnelDialTimeout)
+	if err != nil {
+		r.logger.Err("Failed to connect to %s for reverse port %s: %s", forward.LocalAddress, forward, err)
+		conn.Close()
+		return
+	}
+	go func() {
+		defer local.Close()
+		defer conn.Close()
+		io.Copy(local, conn)
+	}()
+	go func() {
+		defer local.Close()
+		defer conn.Close()
+		io.Copy(conn, local)
+	}()
+}
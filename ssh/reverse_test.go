@@ -0,0 +1,78 @@
+/*
+ * Copyright 2016 Red Hat
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ssh
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseReverseForwards(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected []ReverseForward
+	}{
+		{"", []ReverseForward{}},
+		{"5432:postgres:5432", []ReverseForward{
+			{RemoteAddress: "127.0.0.1:5432", LocalAddress: "postgres:5432"},
+		}},
+		{"0.0.0.0:15432:postgres.db:5432", []ReverseForward{
+			{RemoteAddress: "0.0.0.0:15432", LocalAddress: "postgres.db:5432"},
+		}},
+		{"5432:postgres:5432 61616:broker.messaging:61616,8080:web:80\n\t9090:metrics:9090", []ReverseForward{
+			{RemoteAddress: "127.0.0.1:5432", LocalAddress: "postgres:5432"},
+			{RemoteAddress: "127.0.0.1:61616", LocalAddress: "broker.messaging:61616"},
+			{RemoteAddress: "127.0.0.1:8080", LocalAddress: "web:80"},
+			{RemoteAddress: "127.0.0.1:9090", LocalAddress: "metrics:9090"},
+		}},
+		{" 5432:postgres:5432 , ", []ReverseForward{
+			{RemoteAddress: "127.0.0.1:5432", LocalAddress: "postgres:5432"},
+		}},
+	}
+	for _, test := range tests {
+		actual, err := ParseReverseForwards(test.text)
+		if err != nil {
+			t.Errorf("ParseReverseForwards(%q): unexpected error: %s", test.text, err)
+			continue
+		}
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("ParseReverseForwards(%q): expected %v but got %v", test.text, test.expected, actual)
+		}
+	}
+}
+
+func TestParseReverseForwardsErrors(t *testing.T) {
+	tests := []string{
+		"5432",
+		"5432:postgres",
+		"a:b:c:d:e",
+		"postgres:5432:5432",
+		"5432:postgres:db",
+		"0:postgres:5432",
+		"5432:postgres:65536",
+		"-1:postgres:5432",
+		"5432::5432",
+		"0.0.0.0:5432::5432",
+		"5432:postgres:5432 bad",
+	}
+	for _, text := range tests {
+		_, err := ParseReverseForwards(text)
+		if err == nil {
+			t.Errorf("ParseReverseForwards(%q): expected an error", text)
+		}
+	}
+}
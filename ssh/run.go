@@ -0,0 +1,133 @@
+/*
+ * Copyright 2016 Red Hat
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ssh
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// CommandRunner runs short lived commands such as probes on a host over a persistent SSH connection
+// which is re-established if it fails
+type CommandRunner struct {
+	User       string
+	PrivateKey string
+	Password   string
+	Host       string
+	Port       string
+
+	lock   sync.Mutex
+	client *ssh.Client
+}
+
+type commandResult struct {
+	output []byte
+	err    error
+}
+
+// Run runs the command returning its combined output and exit status. An error is returned if the command
+// could not be run or did not complete within the timeout
+func (r *CommandRunner) Run(cmd string, timeout time.Duration) ([]byte, int, error) {
+	session, err := r.newSession()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer session.Close()
+
+	become := getBecome(r.Host)
+	shell := getShell(r.Host)
+	output := &lockedBuffer{}
+	flushOutput, err := become.attach(session, nil, output, output, false)
+	if err != nil {
+		return nil, 0, err
+	}
+	results := make(chan commandResult, 1)
+	go func() {
+		err := session.Run(shell.Command(become.Command(shell.InWorkingDir(cmd))))
+		flushOutput()
+		results <- commandResult{output.Bytes(), err}
+	}()
+	select {
+	case result := <-results:
+		if result.err != nil {
+			if exitErr, ok := result.err.(*ssh.ExitError); ok {
+				return result.output, exitErr.ExitStatus(), nil
+			}
+			r.reset()
+			return result.output, 0, fmt.Errorf("Failed to run command: %s: %v", cmd, result.err)
+		}
+		return result.output, 0, nil
+	case <-time.After(timeout):
+		return nil, 0, fmt.Errorf("Command did not complete within %s: %s", timeout, cmd)
+	}
+}
+
+// newSession creates a session on the connection; reconnecting if the connection has failed
+func (r *CommandRunner) newSession() (*ssh.Session, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for attempt := 0; ; attempt++ {
+		if r.client == nil {
+			client, err := Dial(r.User, r.PrivateKey, r.Password, r.Host, r.Port)
+			if err != nil {
+				return nil, err
+			}
+			r.client = client
+		}
+		session, err := r.client.NewSession()
+		if err == nil {
+			return session, nil
+		}
+		r.client.Close()
+		r.client = nil
+		if attempt > 0 {
+			return nil, fmt.Errorf("Failed to create session: %s", err)
+		}
+	}
+}
+
+// reset closes the connection so that the next command reconnects
+func (r *CommandRunner) reset() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.client != nil {
+		r.client.Close()
+		r.client = nil
+	}
+}
+
+// lockedBuffer is a buffer for the combined output of a command which is safe to write to concurrently
+type lockedBuffer struct {
+	lock   sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buffer.Write(p)
+}
+
+func (b *lockedBuffer) Bytes() []byte {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return append([]byte{}, b.buffer.Bytes()...)
+}
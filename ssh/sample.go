@@ -0,0 +1,93 @@
+/*
+ * Copyright 2016 Red Hat
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ssh
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ProcessSampler samples the resource usage of the remote process group whose process ID is stored
+// in the state directory on a unix host using `ps`
+type ProcessSampler struct {
+	User       string
+	PrivateKey string
+	Password   string
+	Host       string
+	Port       string
+	StateDir   string
+}
+
+// Sample returns the total CPU time in seconds and the resident memory size in bytes of the remote process group
+func (s *ProcessSampler) Sample() (float64, float64, error) {
+	client, err := Dial(s.User, s.PrivateKey, s.Password, s.Host, s.Port)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer client.Close()
+	script := `PID=$(cat ` + stateFile(s.StateDir, "pid") + ` 2>/dev/null) || exit 1
+ps -e -o pgid=,time=,rss= | awk -v g="$PID" '$1 == g { print $2, $3 }'`
+	var stdout bytes.Buffer
+	err = runStateScript(client, s.Host, script, &stdout, nil)
+	if err != nil {
+		return 0, 0, fmt.Errorf("Failed to sample the remote process: %v", err)
+	}
+	cpuSeconds := 0.0
+	rssBytes := 0.0
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		cpu, err := parseCPUTime(fields[0])
+		if err != nil {
+			return 0, 0, err
+		}
+		rss, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("Invalid resident memory size `%s`: %s", fields[1], err)
+		}
+		cpuSeconds += cpu
+		rssBytes += rss * 1024
+	}
+	return cpuSeconds, rssBytes, nil
+}
+
+// parseCPUTime parses the `[[dd-]hh:]mm:ss` CPU time format of ps returning the number of seconds
+func parseCPUTime(text string) (float64, error) {
+	days := 0.0
+	idx := strings.Index(text, "-")
+	if idx > 0 {
+		value, err := strconv.ParseFloat(text[0:idx], 64)
+		if err != nil {
+			return 0, fmt.Errorf("Invalid CPU time `%s`: %s", text, err)
+		}
+		days = value
+		text = text[idx+1:]
+	}
+	seconds := 0.0
+	for _, part := range strings.Split(text, ":") {
+		value, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return 0, fmt.Errorf("Invalid CPU time `%s`: %s", text, err)
+		}
+		seconds = seconds*60 + value
+	}
+	return days*24*60*60 + seconds, nil
+}
@@ -0,0 +1,150 @@
+/*
+ * Copyright 2016 Red Hat
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ssh
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+const (
+	// ShellTypeSh is the shell type of login shells with POSIX shell syntax such as sh, bash or ksh
+	ShellTypeSh = "sh"
+
+	// ShellTypeCsh is the shell type of csh or tcsh login shells
+	ShellTypeCsh = "csh"
+
+	// ShellTypeFish is the shell type of fish login shells
+	ShellTypeFish = "fish"
+
+	// DefaultShellExecutable is the shell which runs the commands if no executable is specified
+	DefaultShellExecutable = "/bin/sh"
+)
+
+var (
+	shellLock  sync.Mutex
+	shellHosts = map[string]*Shell{}
+)
+
+// Shell describes how commands are run on a host whose login shell may not be a POSIX shell such as csh on AIX
+// or Solaris. The commands are run by the shell executable, quoted for the type of the login shell which the sshd
+// passes the command to, in the working directory
+type Shell struct {
+	// Type is the type of the login shell of the user; either sh, csh or fish
+	Type string
+
+	// Executable is the POSIX shell which runs the commands
+	Executable string
+
+	// WorkingDir is the directory in which the remote command and probes are run; a leading `~/` is the home directory
+	WorkingDir string
+}
+
+// NewShell creates a Shell for the given type of login shell, executable and working directory; the type defaults
+// to sh and the executable to /bin/sh
+func NewShell(shellType string, executable string, workingDir string) (*Shell, error) {
+	shellType = strings.ToLower(strings.TrimSpace(shellType))
+	switch shellType {
+	case "":
+		shellType = ShellTypeSh
+	case ShellTypeSh, ShellTypeCsh, ShellTypeFish:
+	default:
+		return nil, fmt.Errorf("Unsupported shell type `%s` for SSH; expected one of %s, %s or %s", shellType, ShellTypeSh, ShellTypeCsh, ShellTypeFish)
+	}
+	if len(executable) == 0 {
+		executable = DefaultShellExecutable
+	}
+	return &Shell{Type: shellType, Executable: executable, WorkingDir: workingDir}, nil
+}
+
+// SetShell sets the shell used to run the commands on the host; nil passes them to the login shell as they are
+func SetShell(host string, shell *Shell) {
+	shellLock.Lock()
+	defer shellLock.Unlock()
+	if shell == nil {
+		delete(shellHosts, host)
+	} else {
+		shellHosts[host] = shell
+	}
+}
+
+func getShell(host string) *Shell {
+	shellLock.Lock()
+	defer shellLock.Unlock()
+	return shellHosts[host]
+}
+
+// Command returns the command line passed to the login shell by the sshd which runs the given POSIX shell
+// command with the shell executable; or the command itself if there is no shell
+func (s *Shell) Command(cmd string) string {
+	if s == nil {
+		return cmd
+	}
+	return s.Quote(s.Executable) + " -c " + s.Quote(cmd)
+}
+
+// Quote quotes the text as a single argument for the type of the login shell
+func (s *Shell) Quote(text string) string {
+	if s == nil {
+		return shellQuote(text)
+	}
+	switch s.Type {
+	case ShellTypeCsh:
+		return cshQuote(text)
+	case ShellTypeFish:
+		return fishQuote(text)
+	}
+	return shellQuote(text)
+}
+
+// Script returns the command line which runs the given POSIX shell script whatever the type of the login shell
+func (s *Shell) Script(script string) string {
+	if s == nil {
+		return "sh -c " + shellQuote(script)
+	}
+	return s.Command(script)
+}
+
+// InWorkingDir returns the POSIX shell command which runs the given command in the working directory
+func (s *Shell) InWorkingDir(cmd string) string {
+	if s == nil || len(s.WorkingDir) == 0 {
+		return cmd
+	}
+	dir := shellQuote(s.WorkingDir)
+	if s.WorkingDir == "~" {
+		dir = "~"
+	} else if strings.HasPrefix(s.WorkingDir, "~/") {
+		// the tilde is left unquoted so that its expanded to the home directory
+		dir = "~/" + shellQuote(s.WorkingDir[2:])
+	}
+	return "cd " + dir + " && " + cmd
+}
+
+// cshQuote quotes the text as a single argument for csh or tcsh where history substitution and line breaks
+// have to be escaped even within single quotes
+func cshQuote(text string) string {
+	replacer := strings.NewReplacer("'", `'\''`, "!", `\!`, "\n", "\\\n")
+	return "'" + replacer.Replace(text) + "'"
+}
+
+// fishQuote quotes the text as a single argument for fish where backslashes and single quotes are escaped
+// within single quotes
+func fishQuote(text string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, "'", `\'`)
+	return "'" + replacer.Replace(text) + "'"
+}
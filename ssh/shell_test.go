@@ -0,0 +1,186 @@
+/*
+ * Copyright 2016 Red Hat
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ssh
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCshQuote(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected string
+	}{
+		{"", "''"},
+		{"plain", "'plain'"},
+		{"it's", `'it'\''s'`},
+		{"hello!", `'hello\!'`},
+		{"!!", `'\!\!'`},
+		{"$HOME", "'$HOME'"},
+		{"~/app", "'~/app'"},
+		{"line1\nline2", "'line1\\\nline2'"},
+		{"it's $HOME!\n", "'it'\\''s $HOME\\!\\\n'"},
+	}
+	for _, test := range tests {
+		actual := cshQuote(test.text)
+		if actual != test.expected {
+			t.Errorf("cshQuote(%q): expected %q but got %q", test.text, test.expected, actual)
+		}
+	}
+}
+
+func TestFishQuote(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected string
+	}{
+		{"", "''"},
+		{"plain", "'plain'"},
+		{"it's", `'it\'s'`},
+		{`back\slash`, `'back\\slash'`},
+		{`\'`, `'\\\''`},
+		{"hello!", "'hello!'"},
+		{"$HOME", "'$HOME'"},
+		{"~/app", "'~/app'"},
+		{"line1\nline2", "'line1\nline2'"},
+	}
+	for _, test := range tests {
+		actual := fishQuote(test.text)
+		if actual != test.expected {
+			t.Errorf("fishQuote(%q): expected %q but got %q", test.text, test.expected, actual)
+		}
+	}
+}
+
+func TestShellCommand(t *testing.T) {
+	cmd := "echo 'it''s' $HOME! && printf 'a\nb'"
+	tests := []struct {
+		shell    *Shell
+		expected string
+	}{
+		{nil, cmd},
+		{&Shell{Type: ShellTypeSh, Executable: "/bin/sh"}, "'/bin/sh' -c " + shellQuote(cmd)},
+		{&Shell{Type: ShellTypeSh, Executable: "/usr/bin/my shell"}, "'/usr/bin/my shell' -c " + shellQuote(cmd)},
+		{&Shell{Type: ShellTypeCsh, Executable: "/bin/sh"}, "'/bin/sh' -c " + cshQuote(cmd)},
+		{&Shell{Type: ShellTypeFish, Executable: "/bin/sh"}, "'/bin/sh' -c " + fishQuote(cmd)},
+	}
+	for _, test := range tests {
+		actual := test.shell.Command(cmd)
+		if actual != test.expected {
+			t.Errorf("Command(%q) with shell %+v: expected %q but got %q", cmd, test.shell, test.expected, actual)
+		}
+	}
+
+	csh := &Shell{Type: ShellTypeCsh, Executable: "/bin/sh"}
+	actual := csh.Command("echo hi!\nexit")
+	expected := `'/bin/sh' -c 'echo hi\!\` + "\n" + `exit'`
+	if actual != expected {
+		t.Errorf("Command with csh: expected %q but got %q", expected, actual)
+	}
+}
+
+func TestShellScript(t *testing.T) {
+	var shell *Shell
+	actual := shell.Script("echo $$")
+	if actual != "sh -c 'echo $$'" {
+		t.Errorf("Script without a shell: expected `sh -c 'echo $$'` but got `%s`", actual)
+	}
+	shell = &Shell{Type: ShellTypeCsh, Executable: "/bin/sh"}
+	actual = shell.Script("echo $$")
+	if actual != "'/bin/sh' -c 'echo $$'" {
+		t.Errorf("Script with csh: expected `'/bin/sh' -c 'echo $$'` but got `%s`", actual)
+	}
+}
+
+func TestShellInWorkingDir(t *testing.T) {
+	tests := []struct {
+		workingDir string
+		expected   string
+	}{
+		{"", "run.sh"},
+		{"~", "cd ~ && run.sh"},
+		{"~/", "cd ~/'' && run.sh"},
+		{"~/app", "cd ~/'app' && run.sh"},
+		{"~/my app", "cd ~/'my app' && run.sh"},
+		{"~/it's", `cd ~/'it'\''s' && run.sh`},
+		{"~/$HOME!", "cd ~/'$HOME!' && run.sh"},
+		{"~other", "cd '~other' && run.sh"},
+		{"/opt/my app", "cd '/opt/my app' && run.sh"},
+		{"/opt/line1\nline2", "cd '/opt/line1\nline2' && run.sh"},
+	}
+	for _, test := range tests {
+		shell := &Shell{Type: ShellTypeSh, Executable: "/bin/sh", WorkingDir: test.workingDir}
+		actual := shell.InWorkingDir("run.sh")
+		if actual != test.expected {
+			t.Errorf("InWorkingDir with the working directory %q: expected %q but got %q", test.workingDir, test.expected, actual)
+		}
+	}
+	var shell *Shell
+	if actual := shell.InWorkingDir("run.sh"); actual != "run.sh" {
+		t.Errorf("InWorkingDir without a shell: expected `run.sh` but got `%s`", actual)
+	}
+}
+
+func TestShellInWorkingDirChangesDirectory(t *testing.T) {
+	home, err := ioutil.TempDir("", "kansible-home")
+	if err != nil {
+		t.Fatalf("Failed to create a temporary directory: %s", err)
+	}
+	defer os.RemoveAll(home)
+	home, err = filepath.EvalSymlinks(home)
+	if err != nil {
+		t.Fatalf("Failed to resolve the temporary directory: %s", err)
+	}
+	names := []string{"my app", "it's", "$HOME!", "line1\nline2"}
+	for _, name := range names {
+		err := os.Mkdir(filepath.Join(home, name), 0700)
+		if err != nil {
+			t.Fatalf("Failed to create the directory %q: %s", name, err)
+		}
+	}
+
+	tests := []struct {
+		workingDir string
+		expected   string
+	}{
+		{"~", home},
+		{"~/", home},
+		{"~/my app", filepath.Join(home, "my app")},
+		{"~/it's", filepath.Join(home, "it's")},
+		{"~/$HOME!", filepath.Join(home, "$HOME!")},
+		{filepath.Join(home, "line1\nline2"), filepath.Join(home, "line1\nline2")},
+	}
+	for _, test := range tests {
+		shell := &Shell{Type: ShellTypeSh, Executable: "/bin/sh", WorkingDir: test.workingDir}
+		cmd := exec.Command("sh", "-c", shell.InWorkingDir("pwd -P"))
+		cmd.Env = []string{"HOME=" + home, "PATH=" + os.Getenv("PATH")}
+		output, err := cmd.Output()
+		if err != nil {
+			t.Errorf("InWorkingDir with the working directory %q: failed to run: %s", test.workingDir, err)
+			continue
+		}
+		actual := strings.TrimSuffix(string(output), "\n")
+		if actual != test.expected {
+			t.Errorf("InWorkingDir with the working directory %q: expected to run in %q but got %q", test.workingDir, test.expected, actual)
+		}
+	}
+}
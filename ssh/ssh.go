@@ -18,38 +18,28 @@ package ssh
 
 import (
 	"fmt"
-	"io"
-	"io/ioutil"
-	"net"
 	"os"
 	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/fabric8io/kansible/health"
 	"github.com/fabric8io/kansible/log"
+	"github.com/fabric8io/kansible/logtail"
 	"golang.org/x/crypto/ssh"
-	"syscall"
+	"golang.org/x/crypto/ssh/agent"
 )
 
-// RemoteSSHCommand invokes the given command on a host and port
-func RemoteSSHCommand(user string, privateKey string, host string, port string, cmd string, envVars map[string]string) error {
-	if len(privateKey) == 0 {
-		return fmt.Errorf("Could not find PrivateKey for entry %s", host)
-	}
-	log.Info("Connecting to host over SSH on host %s and port %s with user %s with command `%s`", host, port, user, cmd)
-
-	hostPort := net.JoinHostPort(host, port)
-
-	sshConfig := &ssh.ClientConfig{
-		User: user,
-		Auth: []ssh.AuthMethod{
-			PublicKeyFile(privateKey),
-		},
-	}
-	if sshConfig == nil {
-		log.Warn("No sshConfig could be created!")
-	}
-	connection, err := ssh.Dial("tcp", hostPort, sshConfig)
+// RemoteSSHCommand invokes the given command on a host and port. If a state directory is specified the process ID
+// of the remote command is written to it so that the remote process group can be stopped by the stop policy
+// when the pod is signalled or by `kansible kill`; otherwise the stop signal is sent over the session.
+// A pseudo terminal is only requested for the command if pty is true
+func RemoteSSHCommand(user string, privateKey string, password string, host string, port string, cmd string, envVars map[string]string, stateDir string, stop StopPolicy, pty bool) error {
+	logger := log.WithFields(log.Fields{log.FieldAddress: host, log.FieldConnection: "ssh"})
+	logger.Info("Connecting to host over SSH on host %s and port %s with user %s with command `%s`", host, port, user, cmd)
+	connection, err := Dial(user, privateKey, password, host, port)
 	if err != nil {
-		return fmt.Errorf("Failed to dial: %s", err)
+		return err
 	}
 	session, err := connection.NewSession()
 	if err != nil {
@@ -57,69 +47,140 @@ func RemoteSSHCommand(user string, privateKey string, host string, port string,
 	}
 	defer session.Close()
 
-	modes := ssh.TerminalModes{
-		// ssh.ECHO:          0,     // disable echoing
-		ssh.TTY_OP_ISPEED: 14400, // input speed = 14.4kbaud
-		ssh.TTY_OP_OSPEED: 14400, // output speed = 14.4kbaud
-	}
-
-	if err := session.RequestPty("xterm", 80, 40, modes); err != nil {
-		return fmt.Errorf("Request for pseudo terminal failed: %s", err)
-	}
-
-	stdin, err := session.StdinPipe()
-	if err != nil {
-		return fmt.Errorf("Unable to setup stdin for session: %v", err)
+	if ForwardAgent && len(AgentSocket) > 0 {
+		err = agent.ForwardToRemote(connection, AgentSocket)
+		if err == nil {
+			err = agent.RequestAgentForwarding(session)
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to forward the ssh-agent: %s", err)
+		}
 	}
-	go io.Copy(stdin, os.Stdin)
 
-	stdout, err := session.StdoutPipe()
-	if err != nil {
-		return fmt.Errorf("Unable to setup stdout for session: %v", err)
+	if pty {
+		restoreTerminal, err := requestPty(session, logger)
+		if err != nil {
+			return err
+		}
+		defer restoreTerminal()
 	}
-	go io.Copy(os.Stdout, stdout)
 
-	stderr, err := session.StderrPipe()
+	become := getBecome(host)
+	shell := getShell(host)
+	flushOutput, err := become.attach(session, os.Stdin, logtail.Stdout, os.Stderr, pty)
 	if err != nil {
-		return fmt.Errorf("Unable to setup stderr for session: %v", err)
+		return err
 	}
-	go io.Copy(os.Stderr, stderr)
 
-	for envName, envValue := range envVars {
-		log.Info("Setting environment value %s = %s", envName, envValue)
-		if err := session.Setenv(envName, envValue); err != nil {
-			return fmt.Errorf("Could not set environment variable %s = %s over SSH. This could be disabled by the sshd configuration. See the `AcceptEnv` setting in your /etc/ssh/sshd_config more info: http://linux.die.net/man/5/sshd_config . Error: %s", envName, envValue, err)
-		}
+	commandEnvVars := envVars
+	if become == nil {
+		commandEnvVars = setEnv(session, envVars, logger)
 	}
 
 	signals := make(chan os.Signal, 1)
 	signal.Notify(signals, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
-	signaled := false
+	// signaled is closed before the session is closed by a signal so that the resulting error is ignored
+	signaled := make(chan struct{})
 	go func() {
 		<-signals
-		log.Info("Shutting down SSH session.")
-		signaled = true
+		close(signaled)
+		if len(stateDir) > 0 {
+			err := stopProcess(connection, host, stateDir, stop)
+			if err != nil {
+				logger.Warn("%s", err)
+			}
+		} else {
+			logger.Info("Sending SIG%s to the remote process with a grace period of %s", stop.Signal, stop.GracePeriod)
+			err := session.Signal(ssh.Signal(stop.Signal))
+			if err != nil {
+				logger.Warn("Failed to signal the remote process: %s", err)
+			}
+			time.Sleep(stop.GracePeriod)
+		}
+		logger.Info("Shutting down SSH session.")
 		session.Close()
 	}()
 
-	log.Info("Running command %s", cmd)
-	err = session.Run(cmd)
-	if !signaled && err != nil {
-		return fmt.Errorf("Failed to run command: " + cmd + ": %v", err)
+	// the environment variables are passed on the command line for the become user as sudo resets the environment
+	remoteCmd := envCommand(commandEnvVars, shell.InWorkingDir(cmd))
+	if len(stateDir) > 0 {
+		// the shell run as the become user, if there is one, records its process ID in its own state directory
+		// so that the stop script run as the same user can signal it. The process ID is removed again once the
+		// command completes so that a stale process ID is never signalled
+		pidFile := stateFile(stateDir, "pid")
+		remoteCmd = "mkdir -p " + stateDirPath(stateDir) + " && rm -f " + stateFile(stateDir, "exit") + " && echo $$ > " + pidFile + " && " + remoteCmd + "; status=$?; rm -f " + pidFile + "; exit $status"
+	}
+	remoteCmd = become.Command(remoteCmd)
+	logger.Info("Running command %s", cmd)
+	health.SetConnected(true)
+	health.SetProcessAlive(true)
+	err = session.Run(shell.Command(remoteCmd))
+	flushOutput()
+	health.SetProcessAlive(false)
+	health.SetConnected(false)
+	if err != nil && !isClosed(signaled) {
+		if exitErr, ok := err.(*ssh.ExitError); ok {
+			return &ExitError{
+				Command: cmd,
+				Status:  exitErr.ExitStatus(),
+				Signal:  exitErr.Signal(),
+			}
+		}
+		return fmt.Errorf("Failed to run command: "+cmd+": %v", err)
 	}
 	return nil
 }
 
-// PublicKeyFile creates the auth method for the given private key file
-func PublicKeyFile(file string) ssh.AuthMethod {
-	buffer, err := ioutil.ReadFile(file)
-	if err != nil {
-		return nil
+// isClosed returns true if the channel has been closed
+func isClosed(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+// ExitError is returned when the remote command completes with a non zero exit status
+type ExitError struct {
+	Command string
+	Status  int
+	Signal  string
+}
+
+func (e *ExitError) Error() string {
+	if len(e.Signal) > 0 {
+		return fmt.Sprintf("Command `%s` was terminated by signal %s with exit status %d", e.Command, e.Signal, e.Status)
 	}
+	return fmt.Sprintf("Command `%s` completed with exit status %d", e.Command, e.Status)
+}
+
+// ExitStatus returns the exit status of the remote command
+func (e *ExitError) ExitStatus() int {
+	return e.Status
+}
 
-	key, err := ssh.ParsePrivateKey(buffer)
+// Dial opens an SSH connection to the given host and port authenticating with the private key, the ssh-agent
+// or the password. If the host has jump hosts the connection is tunneled through them
+func Dial(user string, privateKey string, password string, host string, port string) (*ssh.Client, error) {
+	jumpClients, err := dialJumpHosts(getJumpHosts(host))
 	if err != nil {
-		return nil
+		return nil, err
+	}
+	var via *ssh.Client
+	if len(jumpClients) > 0 {
+		via = jumpClients[len(jumpClients)-1]
+	}
+	connection, err := dialVia(via, user, privateKey, password, host, port)
+	if err != nil {
+		closeClients(jumpClients)
+		return nil, err
+	}
+	if len(jumpClients) > 0 {
+		go func() {
+			connection.Wait()
+			closeClients(jumpClients)
+		}()
 	}
-	return ssh.PublicKeys(key)
+	return connection, nil
 }
@@ -0,0 +1,165 @@
+/*
+ * Copyright 2016 Red Hat
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ssh
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/fabric8io/kansible/log"
+)
+
+const (
+	// DefaultStopSignal is the signal sent to the remote process group to ask it to stop
+	DefaultStopSignal = "TERM"
+
+	// DefaultStopGracePeriod is how long to wait for the remote process to stop before it is killed
+	DefaultStopGracePeriod = 10 * time.Second
+
+	stateDirPrefix = ".kansible/"
+)
+
+// StopPolicy defines how the remote process is stopped when the pod terminates; the signal is sent to the
+// remote process group and if the process is still running after the grace period it is sent SIGKILL
+type StopPolicy struct {
+	Signal      string
+	GracePeriod time.Duration
+}
+
+// DefaultStopPolicy returns the default StopPolicy
+func DefaultStopPolicy() StopPolicy {
+	return StopPolicy{
+		Signal:      DefaultStopSignal,
+		GracePeriod: DefaultStopGracePeriod,
+	}
+}
+
+// ParseStopSignal parses a signal name such as `TERM`, `SIGINT` or `hup` returning the name without the SIG prefix
+func ParseStopSignal(text string) (string, error) {
+	name := strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(text)), "SIG")
+	if len(name) == 0 {
+		return "", fmt.Errorf("No signal name specified")
+	}
+	for _, ch := range name {
+		if ch < 'A' || ch > 'Z' {
+			if ch < '0' || ch > '9' {
+				return "", fmt.Errorf("Invalid signal name `%s`", text)
+			}
+		}
+	}
+	return name, nil
+}
+
+// StateDir returns the directory on the host, relative to the home directory of the user, used to store the
+// process ID and other state of the remote process with the given name
+func StateDir(name string) string {
+	return stateDirPrefix + safeFileName(name)
+}
+
+// stateDirPath returns the quoted path of the state directory for a POSIX shell script. The state directory is in the
+// home directory of the user running the script, which is the become user if there is one, so that it is owned by the
+// same user as the remote process
+func stateDirPath(stateDir string) string {
+	return `"$HOME"/` + shellQuote(stateDir)
+}
+
+// stateFile returns the quoted path of the file in the state directory for a POSIX shell script
+func stateFile(stateDir string, name string) string {
+	return `"$HOME"/` + shellQuote(stateDir+"/"+name)
+}
+
+// runStateScript runs the POSIX shell script which uses the state directory on the host as the become user, if there
+// is one, writing its output to stdout and stderr
+func runStateScript(client *ssh.Client, host string, script string, stdout io.Writer, stderr io.Writer) error {
+	session, err := client.NewSession()
+	if err != nil {
+		return fmt.Errorf("Failed to create session: %s", err)
+	}
+	defer session.Close()
+	flushOutput, err := getBecome(host).attach(session, nil, stdout, stderr, false)
+	if err != nil {
+		return err
+	}
+	err = session.Run(stateCommand(host, script))
+	flushOutput()
+	return err
+}
+
+// stateCommand returns the command line which runs the POSIX shell script as the become user of the host, if there is one
+func stateCommand(host string, script string) string {
+	become := getBecome(host)
+	shell := getShell(host)
+	if become == nil {
+		return shell.Script(script)
+	}
+	return shell.Command(become.Command(script))
+}
+
+// StopRemoteProcess connects to the host and stops the remote process whose process ID is stored in the given state directory
+func StopRemoteProcess(user string, privateKey string, password string, host string, port string, stateDir string, policy StopPolicy) error {
+	client, err := Dial(user, privateKey, password, host, port)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	return stopProcess(client, host, stateDir, policy)
+}
+
+// stopProcess sends the stop signal to the remote process group whose process ID is stored in the state directory,
+// waits for up to the grace period for it to terminate, then kills it. The script is run as the become user, if
+// there is one, so that it can signal the remote process
+func stopProcess(client *ssh.Client, host string, stateDir string, policy StopPolicy) error {
+	log.Info("Sending SIG%s to the remote process with a grace period of %s", policy.Signal, policy.GracePeriod)
+	var stderr bytes.Buffer
+	err := runStateScript(client, host, stopScript(stateDir, policy), nil, &stderr)
+	if err != nil {
+		return fmt.Errorf("Failed to stop the remote process: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// stopScript returns the POSIX shell script to stop the remote process. The process group is signalled so that
+// any child processes are stopped too, falling back to the process itself if its not a process group leader
+func stopScript(stateDir string, policy StopPolicy) string {
+	seconds := int((policy.GracePeriod + time.Second - 1) / time.Second)
+	pidFile := stateFile(stateDir, "pid")
+	return `[ -f ` + stateFile(stateDir, "exit") + ` ] && exit 0
+[ -e ` + pidFile + ` ] || exit 0
+PID=$(cat ` + pidFile + `) || exit 1
+[ -n "$PID" ] || exit 0
+if ! kill -` + policy.Signal + ` -- -$PID 2>/dev/null && ! kill -` + policy.Signal + ` $PID 2>/dev/null; then
+  ps -p $PID > /dev/null 2>&1 || exit 0
+  echo "Not permitted to signal the remote process $PID" >&2
+  exit 1
+fi
+i=0
+while [ $i -lt ` + strconv.Itoa(seconds) + ` ]; do
+  kill -0 -- -$PID 2>/dev/null || kill -0 $PID 2>/dev/null || exit 0
+  sleep 1
+  i=$((i+1))
+done
+echo "Remote process $PID did not stop within ` + policy.GracePeriod.String() + ` so killing it" >&2
+kill -KILL -- -$PID 2>/dev/null
+kill -KILL $PID 2>/dev/null
+exit 0`
+}
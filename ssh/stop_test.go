@@ -0,0 +1,132 @@
+/*
+ * Copyright 2016 Red Hat
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ssh
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// runStopScript runs the stop script locally with the given home directory returning its exit status
+func runStopScript(t *testing.T, home string, stateDir string) int {
+	cmd := exec.Command("sh", "-c", stopScript(stateDir, StopPolicy{Signal: "TERM", GracePeriod: 2 * time.Second}))
+	cmd.Env = []string{"HOME=" + home, "PATH=" + os.Getenv("PATH")}
+	err := cmd.Run()
+	if err == nil {
+		return 0
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.Sys().(syscall.WaitStatus).ExitStatus()
+	}
+	t.Fatalf("Failed to run the stop script: %s", err)
+	return -1
+}
+
+func TestStopScript(t *testing.T) {
+	home, err := ioutil.TempDir("", "kansible-home")
+	if err != nil {
+		t.Fatalf("Failed to create a temporary directory: %s", err)
+	}
+	defer os.RemoveAll(home)
+
+	process := exec.Command("sleep", "60")
+	process.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	err = process.Start()
+	if err != nil {
+		t.Fatalf("Failed to start a process: %s", err)
+	}
+	defer process.Process.Kill()
+	stopped := make(chan struct{})
+	go func() {
+		process.Wait()
+		close(stopped)
+	}()
+
+	exited := exec.Command("true")
+	err = exited.Run()
+	if err != nil {
+		t.Fatalf("Failed to run a process: %s", err)
+	}
+
+	tests := []struct {
+		name     string
+		files    map[string]string
+		dirs     []string
+		expected int
+	}{
+		{
+			name:     "no process ID file",
+			expected: 0,
+		},
+		{
+			name:     "completed process",
+			files:    map[string]string{"pid": strconv.Itoa(process.Process.Pid), "exit": "0"},
+			expected: 0,
+		},
+		{
+			name:     "process which is no longer running",
+			files:    map[string]string{"pid": strconv.Itoa(exited.Process.Pid)},
+			expected: 0,
+		},
+		{
+			name:     "unreadable process ID file",
+			dirs:     []string{"pid"},
+			expected: 1,
+		},
+		{
+			name:     "running process",
+			files:    map[string]string{"pid": strconv.Itoa(process.Process.Pid)},
+			expected: 0,
+		},
+	}
+	for i, test := range tests {
+		stateDir := ".kansible/test " + strconv.Itoa(i)
+		dir := filepath.Join(home, stateDir)
+		err := os.MkdirAll(dir, 0700)
+		if err != nil {
+			t.Fatalf("Failed to create the state directory: %s", err)
+		}
+		for name, text := range test.files {
+			err := ioutil.WriteFile(filepath.Join(dir, name), []byte(text+"\n"), 0600)
+			if err != nil {
+				t.Fatalf("Failed to write %s: %s", name, err)
+			}
+		}
+		for _, name := range test.dirs {
+			err := os.Mkdir(filepath.Join(dir, name), 0700)
+			if err != nil {
+				t.Fatalf("Failed to create %s: %s", name, err)
+			}
+		}
+		actual := runStopScript(t, home, stateDir)
+		if actual != test.expected {
+			t.Errorf("%s: expected the exit status %d but got %d", test.name, test.expected, actual)
+		}
+	}
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Errorf("Expected the running process to be stopped")
+	}
+}
@@ -0,0 +1,407 @@
+/*
+ * Copyright 2016 Red Hat
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ssh
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/fabric8io/kansible/health"
+	"github.com/fabric8io/kansible/log"
+	"github.com/fabric8io/kansible/logtail"
+	"github.com/fabric8io/kansible/metrics"
+)
+
+const (
+	// DefaultPollInterval is how often the remote process is checked to see if its still running
+	DefaultPollInterval = 5 * time.Second
+
+	// DefaultReconnectTimeout is how long to keep trying to reconnect to the host before giving up
+	DefaultReconnectTimeout = 10 * time.Minute
+
+	maxReconnectBackoff = 30 * time.Second
+)
+
+// Supervisor runs a remote command detached from the SSH session, with its process ID and output written to
+// files in a state directory on the host, so that the remote process survives the SSH connection being dropped.
+// The output is tailed to stdout and the process is polled until it completes; if the connection is dropped
+// the supervisor reconnects with a backoff and re-attaches to the still running process
+type Supervisor struct {
+	User       string
+	PrivateKey string
+	Password   string
+	Host       string
+	Port       string
+	Command    string
+	EnvVars    map[string]string
+
+	// StateDir is the directory on the host, relative to the home directory of the become user if there is one
+	// otherwise the SSH user, for the process ID, output and exit status files
+	StateDir string
+
+	PollInterval     time.Duration
+	ReconnectTimeout time.Duration
+	Stop             StopPolicy
+
+	client *ssh.Client
+	stdout *countingWriter
+	logger *log.Logger
+}
+
+// NewSupervisor creates a Supervisor for the given command using a state directory for the given name
+func NewSupervisor(user string, privateKey string, password string, host string, port string, cmd string, envVars map[string]string, name string) *Supervisor {
+	return &Supervisor{
+		User:             user,
+		PrivateKey:       privateKey,
+		Password:         password,
+		Host:             host,
+		Port:             port,
+		Command:          cmd,
+		EnvVars:          envVars,
+		StateDir:         StateDir(name),
+		PollInterval:     DefaultPollInterval,
+		ReconnectTimeout: DefaultReconnectTimeout,
+		Stop:             DefaultStopPolicy(),
+		stdout:           &countingWriter{writer: logtail.Stdout},
+		logger:           log.WithFields(log.Fields{log.FieldAddress: host, log.FieldConnection: "ssh"}),
+	}
+}
+
+// Run starts the remote command, or re-attaches to it if its already running, then supervises it until it completes
+func (s *Supervisor) Run() error {
+	s.logger.Info("Connecting to host over SSH on host %s and port %s with user %s to supervise command `%s`", s.Host, s.Port, s.User, s.Command)
+	err := s.connect()
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	running, err := s.isRunning()
+	if err != nil {
+		return err
+	}
+	health.SetProcessAlive(running)
+	if running {
+		s.logger.Info("Re-attaching to the running process in %s", s.StateDir)
+		s.stdout.offset = s.outputSize()
+	} else {
+		completed, err := s.hasExitStatus()
+		if err != nil {
+			return err
+		}
+		if completed {
+			// the process completed while no pod was attached so lets report its exit status rather than run it again
+			s.logger.Info("The remote process in %s completed while the pod was disconnected", s.StateDir)
+			return s.exitStatus()
+		}
+		err = s.start()
+		if err != nil {
+			return err
+		}
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	defer signal.Stop(signals)
+
+	for {
+		done, err := s.supervise(signals)
+		if done {
+			return err
+		}
+		s.logger.Warn("Lost the SSH connection to host %s: %s", s.Host, err)
+		err = s.reconnect()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// supervise tails the output and polls the remote process until it completes, the connection fails or
+// the pod is signalled. Returns true if supervision has completed along with the result
+func (s *Supervisor) supervise(signals chan os.Signal) (bool, error) {
+	tail, err := s.tail()
+	if err != nil {
+		return false, err
+	}
+	defer tail.Close()
+
+	ticker := time.NewTicker(s.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-signals:
+			s.logger.Info("Stopping the remote process.")
+			err = stopProcess(s.client, s.Host, s.StateDir, s.Stop)
+			if err != nil {
+				s.logger.Warn("%s", err)
+			} else {
+				// the process was stopped rather than completing so the next pod should start it again
+				s.discardExitStatus()
+			}
+			return true, nil
+		case <-ticker.C:
+			running, err := s.isRunning()
+			if err != nil {
+				health.SetConnected(false)
+				return false, err
+			}
+			health.SetProcessAlive(running)
+			if !running {
+				tail.Close()
+				s.flushOutput()
+				return true, s.exitStatus()
+			}
+		}
+	}
+}
+
+// start starts the remote command detached from the SSH session
+func (s *Supervisor) start() error {
+	session, err := s.client.NewSession()
+	if err != nil {
+		return fmt.Errorf("Failed to create session: %s", err)
+	}
+	defer session.Close()
+	become := getBecome(s.Host)
+	commandEnvVars := s.EnvVars
+	if become == nil {
+		commandEnvVars = setEnv(session, s.EnvVars, s.logger)
+	}
+	flushOutput, err := become.attach(session, nil, nil, os.Stderr, false)
+	if err != nil {
+		return err
+	}
+	s.logger.Info("Running command %s", s.Command)
+	s.logger.Debug("Starting detached process with: %s", s.startScript(maskEnv(commandEnvVars)))
+	err = session.Run(stateCommand(s.Host, s.startScript(commandEnvVars)))
+	flushOutput()
+	if err != nil {
+		return fmt.Errorf("Failed to start command: %s: %v", s.Command, err)
+	}
+	s.stdout.offset = 0
+	health.SetProcessAlive(true)
+	return nil
+}
+
+// startScript returns the script which starts the remote command detached from the SSH session with the given
+// environment variables. The script is run as the become user, if there is one, so that the process and its state
+// files are owned by the same user. The process is started in its own session if setsid is available so that its
+// process group can be stopped
+func (s *Supervisor) startScript(envVars map[string]string) string {
+	wrapped := envCommand(envVars, s.shell().InWorkingDir(s.Command)) + "; echo $? > " + s.file("exit")
+	return "mkdir -p " + stateDirPath(s.StateDir) + " && rm -f " + s.file("exit") +
+		" && { $(command -v setsid) nohup sh -c " + shellQuote(wrapped) + " > " + s.file("out.log") + " 2>&1 < /dev/null & echo $! > " + s.file("pid") + "; }"
+}
+
+// isRunning returns true if the remote process is still running
+func (s *Supervisor) isRunning() (bool, error) {
+	return s.test("test -f " + s.file("pid") + " && kill -0 $(cat " + s.file("pid") + ") 2>/dev/null")
+}
+
+// hasExitStatus returns true if the remote process has completed and its exit status has not been reported yet
+func (s *Supervisor) hasExitStatus() (bool, error) {
+	return s.test("test -f " + s.file("exit"))
+}
+
+// test returns true if the given command succeeds on the host
+func (s *Supervisor) test(cmd string) (bool, error) {
+	err := runStateScript(s.client, s.Host, cmd, nil, nil)
+	if err != nil {
+		if _, ok := err.(*ssh.ExitError); ok {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// discardExitStatus removes the process ID and exit status files so that the next pod starts the command again
+func (s *Supervisor) discardExitStatus() {
+	_, err := s.output("rm -f " + s.file("pid") + " " + s.file("exit"))
+	if err != nil {
+		s.logger.Warn("Failed to remove the exit status of the stopped process: %s", err)
+	}
+}
+
+// exitStatus returns the result of the remote command from its exit status file. The process ID and exit status
+// files are then removed so that the status is only reported once and the next pod starts the command again
+func (s *Supervisor) exitStatus() error {
+	text, err := s.output("cat " + s.file("exit") + " && rm -f " + s.file("pid") + " " + s.file("exit"))
+	if err != nil {
+		return fmt.Errorf("Could not find the exit status of command: %s: %v", s.Command, err)
+	}
+	status, err := strconv.Atoi(strings.TrimSpace(text))
+	if err != nil {
+		return fmt.Errorf("Invalid exit status `%s` of command: %s", strings.TrimSpace(text), s.Command)
+	}
+	if status != 0 {
+		return &ExitError{
+			Command: s.Command,
+			Status:  status,
+		}
+	}
+	return nil
+}
+
+// tail starts a session which copies the output of the remote process to stdout from the current offset
+func (s *Supervisor) tail() (*ssh.Session, error) {
+	session, err := s.client.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	_, err = getBecome(s.Host).attach(session, nil, s.stdout, os.Stderr, false)
+	if err == nil {
+		err = session.Start(stateCommand(s.Host, "tail "+s.stdout.tailArgs()+" -f "+s.file("out.log")))
+	}
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	return session, nil
+}
+
+// flushOutput copies any remaining output of the completed remote process to stdout
+func (s *Supervisor) flushOutput() {
+	err := runStateScript(s.client, s.Host, "tail "+s.stdout.tailArgs()+" "+s.file("out.log"), s.stdout, os.Stderr)
+	if err != nil {
+		s.logger.Warn("Failed to copy the remaining output: %s", err)
+	}
+}
+
+// outputSize returns the current size of the output of the remote process so that a re-attached
+// supervisor only tails the new output
+func (s *Supervisor) outputSize() int64 {
+	text, err := s.output("wc -c < " + s.file("out.log"))
+	if err != nil {
+		return 0
+	}
+	size, err := strconv.ParseInt(strings.TrimSpace(text), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return size
+}
+
+func (s *Supervisor) connect() error {
+	client, err := Dial(s.User, s.PrivateKey, s.Password, s.Host, s.Port)
+	if err != nil {
+		return err
+	}
+	s.client = client
+	health.SetConnected(true)
+	return nil
+}
+
+// reconnect reconnects to the host with an exponential backoff until the reconnect timeout expires
+func (s *Supervisor) reconnect() error {
+	s.close()
+	deadline := time.Now().Add(s.ReconnectTimeout)
+	backoff := time.Second
+	for {
+		err := s.connect()
+		if err == nil {
+			s.logger.Info("Reconnected to host %s", s.Host)
+			metrics.Reconnected()
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Failed to reconnect to host %s within %s: %s", s.Host, s.ReconnectTimeout, err)
+		}
+		s.logger.Warn("Failed to reconnect to host %s, retrying in %s: %s", s.Host, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > maxReconnectBackoff {
+			backoff = maxReconnectBackoff
+		}
+	}
+}
+
+func (s *Supervisor) close() {
+	health.SetConnected(false)
+	if s.client != nil {
+		s.client.Close()
+		s.client = nil
+	}
+}
+
+// output runs the given command on the host returning its output
+func (s *Supervisor) output(cmd string) (string, error) {
+	var stdout bytes.Buffer
+	err := runStateScript(s.client, s.Host, cmd, &stdout, nil)
+	return stdout.String(), err
+}
+
+// shell returns the shell which runs the commands on the host
+func (s *Supervisor) shell() *Shell {
+	return getShell(s.Host)
+}
+
+// file returns the quoted path of the file in the state directory
+func (s *Supervisor) file(name string) string {
+	return stateFile(s.StateDir, name)
+}
+
+// countingWriter writes to the underlying writer counting the bytes written so that the
+// output can be tailed from the same offset after reconnecting
+type countingWriter struct {
+	writer io.Writer
+	lock   sync.Mutex
+	// offset is the offset in the remote output of the next byte to be written
+	offset int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	n, err := w.writer.Write(p)
+	w.offset += int64(n)
+	return n, err
+}
+
+// tailArgs returns the arguments for tail to output the file from the current offset
+func (w *countingWriter) tailArgs() string {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return "-c +" + strconv.FormatInt(w.offset+1, 10)
+}
+
+// shellQuote quotes the given text as a single argument for a POSIX shell
+func shellQuote(text string) string {
+	return "'" + strings.Replace(text, "'", `'\''`, -1) + "'"
+}
+
+// safeFileName replaces any characters in the name which are not safe to use unquoted in a file name
+func safeFileName(name string) string {
+	buffer := []byte(name)
+	for i, ch := range buffer {
+		if !((ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || (ch >= '0' && ch <= '9') || ch == '-' || ch == '_' || ch == '.') {
+			buffer[i] = '_'
+		}
+	}
+	return string(buffer)
+}
@@ -0,0 +1,146 @@
+/*
+ * Copyright 2016 Red Hat
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ssh
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// followInterval is how often, in seconds, a followed file is checked to see if it has been removed, rotated
+// or truncated
+const followInterval = "2"
+
+// LogFollower follows log files on a unix host over SSH. The files are followed with the portable `tail -f` which
+// is restarted if the file is rotated or truncated. A single SSH connection is shared by all the files with a
+// session for each file
+type LogFollower struct {
+	User       string
+	PrivateKey string
+	Password   string
+	Host       string
+	Port       string
+
+	lock   sync.Mutex
+	client *ssh.Client
+}
+
+// List returns the files on the host which match the given glob patterns
+func (f *LogFollower) List(patterns []string) ([]string, error) {
+	session, err := f.newSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	become := getBecome(f.Host)
+	output := &bytes.Buffer{}
+	flushOutput, err := become.attach(session, nil, output, nil, false)
+	if err != nil {
+		return nil, err
+	}
+	// the patterns are left unquoted so that they are expanded by the remote shell
+	err = session.Run(getShell(f.Host).Command(become.Command("for f in " + strings.Join(patterns, " ") + `; do [ -f "$f" ] && echo "$f"; done; true`)))
+	flushOutput()
+	data := output.Bytes()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list the files matching %s: %v", strings.Join(patterns, " "), err)
+	}
+	answer := []string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		if len(line) > 0 {
+			answer = append(answer, line)
+		}
+	}
+	return answer, nil
+}
+
+// Follow copies the lines appended to the file to the writer until the file is removed or the connection fails
+func (f *LogFollower) Follow(file string, out io.Writer) error {
+	session, err := f.newSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	become := getBecome(f.Host)
+	flushOutput, err := become.attach(session, nil, out, os.Stderr, false)
+	if err != nil {
+		return err
+	}
+	defer flushOutput()
+	return session.Run(getShell(f.Host).Command(become.Command(followScript(file))))
+}
+
+// followScript returns the POSIX shell script which follows the file with `tail -f` until the file is removed.
+// If the file is replaced, such as by log rotation, or truncated then tail is restarted from the start of the file.
+// If tail exits by itself, such as when the session is closed, the script exits with its status
+func followScript(file string) string {
+	return `f=` + shellQuote(file) + `
+args='-n 0'
+while :; do
+  i=0
+  while [ ! -f "$f" ]; do
+    [ $i -ge 5 ] && exit 0
+    i=$((i+1))
+    sleep 1
+  done
+  id=$(ls -di "$f" 2>/dev/null)
+  size=0
+  tail $args -f "$f" &
+  t=$!
+  while sleep ` + followInterval + `; do
+    kill -0 $t 2>/dev/null || { wait $t; exit $?; }
+    [ -f "$f" ] && [ "$(ls -di "$f" 2>/dev/null)" = "$id" ] || break
+    s=$(($(wc -c < "$f" 2>/dev/null)))
+    [ $s -lt $size ] && break
+    size=$s
+  done
+  kill $t 2>/dev/null
+  wait $t 2>/dev/null
+  args='-n +1'
+done`
+}
+
+// newSession creates a session on the shared connection to the host, connecting if there is no connection.
+// If the connection has failed its closed so that the next session reconnects
+func (f *LogFollower) newSession() (*ssh.Session, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.client == nil {
+		client, err := Dial(f.User, f.PrivateKey, f.Password, f.Host, f.Port)
+		if err != nil {
+			return nil, err
+		}
+		f.client = client
+	}
+	session, err := f.client.NewSession()
+	if err != nil {
+		if _, ok := err.(*ssh.OpenChannelError); !ok {
+			// the connection has failed rather than the host refusing another session
+			f.client.Close()
+			f.client = nil
+		}
+		return nil, fmt.Errorf("Failed to create session: %s", err)
+	}
+	return session, nil
+}
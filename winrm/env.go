@@ -0,0 +1,82 @@
+/*
+ * Copyright 2016 Red Hat
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package winrm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/masterzen/winrm/winrm"
+
+	"github.com/fabric8io/kansible/ansible"
+)
+
+// envCommand returns the command which sets the environment variables and changes to the working directory before
+// running the given command. WinRM has no way to pass environment variables so they are set on the command line;
+// via `set` for cmd or `$env:` for PowerShell in which case the command is run by PowerShell
+func envCommand(envVars map[string]string, workingDir string, command string, shellType string) (string, error) {
+	if len(envVars) == 0 && len(workingDir) == 0 {
+		return command, nil
+	}
+	names := sortedNames(envVars)
+	var buffer []string
+	if shellType == ansible.ShellTypePowerShell {
+		for _, name := range names {
+			buffer = append(buffer, "$env:"+name+" = "+powershellQuote(envVars[name])+"; ")
+		}
+		if len(workingDir) > 0 {
+			buffer = append(buffer, "Set-Location -LiteralPath "+powershellQuote(workingDir)+"; ")
+		}
+		return winrm.Powershell(strings.Join(buffer, "") + command + "; exit $LASTEXITCODE"), nil
+	}
+	for _, name := range names {
+		value := envVars[name]
+		if strings.ContainsAny(name+value, "\r\n") || strings.ContainsAny(name, "=%^&|<>\"") {
+			return "", fmt.Errorf("Cannot set environment variable %s with cmd as its name or value contains line breaks or special characters; use %s=%s instead", name, ansible.AnsibleVariableShellType, ansible.ShellTypePowerShell)
+		}
+		buffer = append(buffer, "set "+name+"="+cmdEscape(value)+"&& ")
+	}
+	if len(workingDir) > 0 {
+		if strings.ContainsAny(workingDir, "\r\n\"") {
+			return "", fmt.Errorf("Cannot change to the working directory `%s` with cmd as it contains line breaks or quotes", workingDir)
+		}
+		buffer = append(buffer, "cd /d \""+workingDir+"\"&& ")
+	}
+	return strings.Join(buffer, "") + command, nil
+}
+
+// cmdEscape escapes the characters which are special to cmd on the command line with `^`
+func cmdEscape(text string) string {
+	var buffer []rune
+	for _, r := range text {
+		if strings.ContainsRune("^&|<>()%!\"", r) {
+			buffer = append(buffer, '^')
+		}
+		buffer = append(buffer, r)
+	}
+	return string(buffer)
+}
+
+func sortedNames(envVars map[string]string) []string {
+	names := []string{}
+	for name := range envVars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
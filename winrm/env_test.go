@@ -0,0 +1,143 @@
+/*
+ * Copyright 2016 Red Hat
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package winrm
+
+import (
+	"testing"
+
+	"github.com/masterzen/winrm/winrm"
+
+	"github.com/fabric8io/kansible/ansible"
+)
+
+func TestCmdEscape(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected string
+	}{
+		{"plain", "plain"},
+		{"a b", "a b"},
+		{"a^b", "a^^b"},
+		{"a&b", "a^&b"},
+		{"a|b", "a^|b"},
+		{"<in>", "^<in^>"},
+		{"(x)", "^(x^)"},
+		{"%PATH%", "^%PATH^%"},
+		{"hi!", "hi^!"},
+		{`say "hi"`, `say ^"hi^"`},
+		{"it's $HOME", "it's $HOME"},
+	}
+	for _, test := range tests {
+		actual := cmdEscape(test.text)
+		if actual != test.expected {
+			t.Errorf("cmdEscape(%q): expected `%s` but got `%s`", test.text, test.expected, actual)
+		}
+	}
+}
+
+func TestEnvCommandCmd(t *testing.T) {
+	tests := []struct {
+		envVars    map[string]string
+		workingDir string
+		expected   string
+	}{
+		{nil, "", "run.bat"},
+		{map[string]string{"A": "1"}, "", "set A=1&& run.bat"},
+		{map[string]string{"B": "2", "A": "1"}, "", "set A=1&& set B=2&& run.bat"},
+		{map[string]string{"A": `x^&|<>%!"y`}, "", `set A=x^^^&^|^<^>^%^!^"y&& run.bat`},
+		{map[string]string{"A": "a b"}, `C:\My App`, `set A=a b&& cd /d "C:\My App"&& run.bat`},
+		{nil, `C:\app`, `cd /d "C:\app"&& run.bat`},
+	}
+	for _, test := range tests {
+		actual, err := envCommand(test.envVars, test.workingDir, "run.bat", ansible.ShellTypeCmd)
+		if err != nil {
+			t.Errorf("envCommand(%q, %q): unexpected error: %s", test.envVars, test.workingDir, err)
+			continue
+		}
+		if actual != test.expected {
+			t.Errorf("envCommand(%q, %q): expected `%s` but got `%s`", test.envVars, test.workingDir, test.expected, actual)
+		}
+	}
+}
+
+func TestEnvCommandCmdErrors(t *testing.T) {
+	tests := []struct {
+		envVars    map[string]string
+		workingDir string
+	}{
+		{map[string]string{"A": "line1\nline2"}, ""},
+		{map[string]string{"A": "line1\rline2"}, ""},
+		{map[string]string{"A=B": "1"}, ""},
+		{map[string]string{"A&B": "1"}, ""},
+		{map[string]string{"%A%": "1"}, ""},
+		{nil, "C:\\app\n"},
+		{nil, `C:\"app"`},
+	}
+	for _, test := range tests {
+		actual, err := envCommand(test.envVars, test.workingDir, "run.bat", ansible.ShellTypeCmd)
+		if err == nil {
+			t.Errorf("envCommand(%q, %q): expected an error but got `%s`", test.envVars, test.workingDir, actual)
+		}
+	}
+}
+
+func TestEnvCommandPowerShell(t *testing.T) {
+	tests := []struct {
+		envVars    map[string]string
+		workingDir string
+		expected   string
+	}{
+		{map[string]string{"A": "1"}, "", "$env:A = '1'; run.ps1; exit $LASTEXITCODE"},
+		{map[string]string{"B": "2", "A": "1"}, "", "$env:A = '1'; $env:B = '2'; run.ps1; exit $LASTEXITCODE"},
+		{map[string]string{"A": "it's"}, "", "$env:A = 'it''s'; run.ps1; exit $LASTEXITCODE"},
+		{map[string]string{"A": "$HOME `n"}, "", "$env:A = '$HOME `n'; run.ps1; exit $LASTEXITCODE"},
+		{map[string]string{"A": `x^&|<>%!"y`}, "", `$env:A = 'x^&|<>%!"y'; run.ps1; exit $LASTEXITCODE`},
+		{map[string]string{"A": "line1\nline2"}, "", "$env:A = 'line1\nline2'; run.ps1; exit $LASTEXITCODE"},
+		{nil, `C:\Bob's App`, `Set-Location -LiteralPath 'C:\Bob''s App'; run.ps1; exit $LASTEXITCODE`},
+	}
+	for _, test := range tests {
+		actual, err := envCommand(test.envVars, test.workingDir, "run.ps1", ansible.ShellTypePowerShell)
+		if err != nil {
+			t.Errorf("envCommand(%q, %q): unexpected error: %s", test.envVars, test.workingDir, err)
+			continue
+		}
+		expected := winrm.Powershell(test.expected)
+		if actual != expected {
+			t.Errorf("envCommand(%q, %q): expected the PowerShell script `%s` but got `%s`", test.envVars, test.workingDir, test.expected, actual)
+		}
+	}
+}
+
+func TestPowershellQuote(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected string
+	}{
+		{"", "''"},
+		{"plain", "'plain'"},
+		{"it's", "'it''s'"},
+		{"''", "''''''"},
+		{"$env:PATH", "'$env:PATH'"},
+		{"`backtick`", "'`backtick`'"},
+	}
+	for _, test := range tests {
+		actual := powershellQuote(test.text)
+		if actual != test.expected {
+			t.Errorf("powershellQuote(%q): expected `%s` but got `%s`", test.text, test.expected, actual)
+		}
+	}
+}
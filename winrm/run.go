@@ -0,0 +1,112 @@
+/*
+ * Copyright 2016 Red Hat
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package winrm
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/masterzen/winrm/winrm"
+)
+
+// CommandRunner runs short lived commands such as probes on a windows host reusing the WinRM client
+type CommandRunner struct {
+	User     string
+	Password string
+	Host     string
+	Port     string
+
+	lock   sync.Mutex
+	client *winrm.Client
+}
+
+// Run runs the command returning its combined output and exit code. An error is returned if the command
+// could not be run or did not complete within the timeout
+func (r *CommandRunner) Run(commandText string, timeout time.Duration) ([]byte, int, error) {
+	client, err := r.getClient()
+	if err != nil {
+		return nil, 0, err
+	}
+	shell, err := client.CreateShell()
+	if err != nil {
+		return nil, 0, fmt.Errorf("Impossible to create WinRM shell: %s", err)
+	}
+	defer shell.Close()
+	cmd, err := shell.Execute(commandText)
+	if err != nil {
+		return nil, 0, fmt.Errorf("Impossible to create Command %s", err)
+	}
+
+	output := &syncBuffer{}
+	done := make(chan error, 2)
+	go func() {
+		_, err := io.Copy(output, cmd.Stdout)
+		done <- err
+	}()
+	go func() {
+		_, err := io.Copy(output, cmd.Stderr)
+		done <- err
+	}()
+	deadline := time.After(timeout)
+	for i := 0; i < 2; i++ {
+		select {
+		case err = <-done:
+			if err != nil {
+				return output.Bytes(), 0, fmt.Errorf("Failed to run command '%s': %s", commandText, err)
+			}
+		case <-deadline:
+			return output.Bytes(), 0, fmt.Errorf("Command did not complete within %s: %s", timeout, commandText)
+		}
+	}
+	cmd.Wait()
+	return output.Bytes(), cmd.ExitCode(), nil
+}
+
+func (r *CommandRunner) getClient() (*winrm.Client, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.client == nil {
+		client, err := newClient(r.User, r.Password, r.Host, r.Port)
+		if err != nil {
+			return nil, err
+		}
+		r.client = client
+	}
+	return r.client, nil
+}
+
+// syncBuffer is a buffer which can be written to by multiple goroutines
+type syncBuffer struct {
+	lock   sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buffer.Write(p)
+}
+
+// Bytes returns a copy of the contents of the buffer
+func (b *syncBuffer) Bytes() []byte {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return append([]byte{}, b.buffer.Bytes()...)
+}
@@ -0,0 +1,65 @@
+/*
+ * Copyright 2016 Red Hat
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package winrm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/masterzen/winrm/winrm"
+)
+
+// ProcessSampler samples the resource usage of the processes with the given name on a windows host using `Get-Process`
+type ProcessSampler struct {
+	User        string
+	Password    string
+	Host        string
+	Port        string
+	ProcessName string
+}
+
+// Sample returns the total CPU time in seconds and the working set size in bytes of the processes
+func (s *ProcessSampler) Sample() (float64, float64, error) {
+	client, err := newClient(s.User, s.Password, s.Host, s.Port)
+	if err != nil {
+		return 0, 0, err
+	}
+	script := "$p = Get-Process -Name " + powershellQuote(s.ProcessName) + " -ErrorAction Stop; " +
+		"[string]::Format([Globalization.CultureInfo]::InvariantCulture, '{0} {1}', " +
+		"($p | Measure-Object -Property CPU -Sum).Sum, ($p | Measure-Object -Property WorkingSet64 -Sum).Sum)"
+	stdout, stderr, exitCode, err := client.RunWithString(winrm.Powershell(script), "")
+	if err != nil {
+		return 0, 0, fmt.Errorf("Failed to sample process %s: %s", s.ProcessName, err)
+	}
+	if exitCode != 0 {
+		return 0, 0, fmt.Errorf("Failed to sample process %s got exit code %d: %s", s.ProcessName, exitCode, stderr)
+	}
+	fields := strings.Fields(stdout)
+	if len(fields) != 2 {
+		return 0, 0, fmt.Errorf("Unexpected output sampling process %s: %s", s.ProcessName, stdout)
+	}
+	cpuSeconds, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("Invalid CPU time `%s`: %s", fields[0], err)
+	}
+	workingSet, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("Invalid working set size `%s`: %s", fields[1], err)
+	}
+	return cpuSeconds, workingSet, nil
+}
@@ -0,0 +1,108 @@
+/*
+ * Copyright 2016 Red Hat
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package winrm
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/masterzen/winrm/winrm"
+)
+
+// LogFollower follows log files on a windows host over WinRM using the PowerShell `Get-Content -Wait` cmdlet
+type LogFollower struct {
+	User     string
+	Password string
+	Host     string
+	Port     string
+}
+
+// List returns the files on the host which match the given glob patterns
+func (f *LogFollower) List(patterns []string) ([]string, error) {
+	client, err := f.client()
+	if err != nil {
+		return nil, err
+	}
+	quoted := []string{}
+	for _, pattern := range patterns {
+		quoted = append(quoted, powershellQuote(pattern))
+	}
+	script := "Get-ChildItem -Path " + strings.Join(quoted, ",") + " -ErrorAction SilentlyContinue | Where-Object { -not $_.PSIsContainer } | ForEach-Object { $_.FullName }"
+	stdout, stderr, exitCode, err := client.RunWithString(winrm.Powershell(script), "")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list the files matching %s: %s", strings.Join(patterns, " "), err)
+	}
+	if exitCode != 0 {
+		return nil, fmt.Errorf("Failed to list the files matching %s got exit code %d: %s", strings.Join(patterns, " "), exitCode, stderr)
+	}
+	answer := []string{}
+	for _, line := range strings.Split(stdout, "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) > 0 {
+			answer = append(answer, line)
+		}
+	}
+	return answer, nil
+}
+
+// Follow copies the lines appended to the file to the writer until the connection fails
+func (f *LogFollower) Follow(file string, out io.Writer) error {
+	client, err := f.client()
+	if err != nil {
+		return err
+	}
+	shell, err := client.CreateShell()
+	if err != nil {
+		return fmt.Errorf("Impossible to create WinRM shell: %s", err)
+	}
+	defer shell.Close()
+	cmd, err := shell.Execute(winrm.Powershell("Get-Content -LiteralPath " + powershellQuote(file) + " -Wait -Tail 0"))
+	if err != nil {
+		return fmt.Errorf("Impossible to create Command %s", err)
+	}
+	go io.Copy(os.Stderr, cmd.Stderr)
+	_, err = io.Copy(out, cmd.Stdout)
+	cmd.Wait()
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("Get-Content completed with exit code %d", cmd.ExitCode())
+}
+
+func (f *LogFollower) client() (*winrm.Client, error) {
+	return newClient(f.User, f.Password, f.Host, f.Port)
+}
+
+// newClient creates a WinRM client for the given host and port
+func newClient(user string, password string, host string, port string) (*winrm.Client, error) {
+	portNumber, err := parsePortNumber(port)
+	if err != nil {
+		return nil, err
+	}
+	client, err := winrm.NewClient(&winrm.Endpoint{Host: host, Port: portNumber, HTTPS: false, Insecure: false}, user, password)
+	if err != nil {
+		return nil, fmt.Errorf("Could not create WinRM client: %s", err)
+	}
+	return client, nil
+}
+
+// powershellQuote quotes the given text as a single quoted PowerShell string
+func powershellQuote(text string) string {
+	return "'" + strings.Replace(text, "'", "''", -1) + "'"
+}
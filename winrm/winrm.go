@@ -28,16 +28,21 @@ import (
 	client "k8s.io/kubernetes/pkg/client/unversioned"
 
 	"github.com/fabric8io/kansible/ansible"
+	"github.com/fabric8io/kansible/health"
 	"github.com/fabric8io/kansible/log"
+	"github.com/fabric8io/kansible/logtail"
 )
 
-// RemoteWinRmCommand runs the remote command on a windows machine
-func RemoteWinRmCommand(user string, password string, host string, port string, commandText string, c *client.Client, rc *api.ReplicationController, hostName string) error {
+// RemoteWinRmCommand runs the remote command on a windows machine. The claimName is the name of the host
+// (or the slot on the host) claimed by this pod which is used to store the shell ID on the RC. The command is run in
+// the working directory unless its blank
+func RemoteWinRmCommand(user string, password string, host string, port string, commandText string, envVars map[string]string, shellType string, workingDir string, c *client.Client, rc *api.ReplicationController, claimName string) error {
 	portNumber, err := parsePortNumber(port)
 	if err != nil {
 		return err
 	}
-	log.Info("Connecting to windows host over WinRM on host %s and port %d with user %s with command `%s`", host, portNumber, user, commandText)
+	logger := log.WithFields(log.Fields{log.FieldAddress: host, log.FieldConnection: ansible.ConnectionWinRM})
+	logger.Info("Connecting to windows host over WinRM on host %s and port %d with user %s with command `%s`", host, portNumber, user, commandText)
 	client, err := winrm.NewClient(&winrm.Endpoint{Host: host, Port: portNumber, HTTPS: false, Insecure: false}, user, password)
 	if err != nil {
 		return fmt.Errorf("Could not create WinRM client: %s", err)
@@ -49,14 +54,14 @@ func RemoteWinRmCommand(user string, password string, host string, port string,
 		isBash = true
 	}
 	if rc.ObjectMeta.Annotations != nil && !isBash {
-		oldShellID := rc.ObjectMeta.Annotations[ansible.WinRMShellAnnotationPrefix+hostName]
+		oldShellID := rc.ObjectMeta.Annotations[ansible.WinRMShellAnnotationPrefix+claimName]
 		if len(oldShellID) > 0 {
 			// lets close the previously running shell on this machine
-			log.Info("Closing the old WinRM Shell %s", oldShellID)
+			logger.Info("Closing the old WinRM Shell %s", oldShellID)
 			shell := client.NewShell(oldShellID)
 			err = shell.Close()
 			if err != nil {
-				log.Warn("Failed to close shell %s. Error: %s", oldShellID, err)
+				logger.Warn("Failed to close shell %s. Error: %s", oldShellID, err)
 			}
 		}
 	}
@@ -67,38 +72,80 @@ func RemoteWinRmCommand(user string, password string, host string, port string,
 	}
 	defer shell.Close()
 	shellID := shell.ShellId
-	log.Info("Created WinRM Shell %s", shellID)
+	logger.Info("Created WinRM Shell %s", shellID)
 
 	if rc != nil && c != nil && !isBash {
-		rc.ObjectMeta.Annotations[ansible.WinRMShellAnnotationPrefix+hostName] = shellID
+		rc.ObjectMeta.Annotations[ansible.WinRMShellAnnotationPrefix+claimName] = shellID
 		_, err = c.ReplicationControllers(rc.ObjectMeta.Namespace).UpdateStatus(rc)
 		if err != nil {
 			return err
 		}
 	}
 
+	for _, name := range sortedNames(envVars) {
+		logger.Info("Setting environment variable %s", name)
+	}
+	remoteCommand, err := envCommand(envVars, workingDir, commandText, shellType)
+	if err != nil {
+		return err
+	}
 	var cmd *winrm.Command
-	cmd, err = shell.Execute(commandText)
+	cmd, err = shell.Execute(remoteCommand)
 	if err != nil {
 		return fmt.Errorf("Impossible to create Command %s\n", err)
 	}
 
+	health.SetConnected(true)
+	health.SetProcessAlive(true)
+	defer health.SetConnected(false)
+	defer health.SetProcessAlive(false)
+
 	go io.Copy(cmd.Stdin, os.Stdin)
-	go io.Copy(os.Stdout, cmd.Stdout)
-	go io.Copy(os.Stderr, cmd.Stderr)
+	outputErrors := make(chan error, 2)
+	go func() {
+		_, err := io.Copy(logtail.Stdout, cmd.Stdout)
+		outputErrors <- err
+	}()
+	go func() {
+		_, err := io.Copy(os.Stderr, cmd.Stderr)
+		outputErrors <- err
+	}()
 
 	cmd.Wait()
 
-	exitCode := cmd.ExitCode()
-	if exitCode > 0 {
-		return fmt.Errorf("Failed to run command '%s' got exit code %d", commandText, exitCode)
+	// the output streams are closed with the error if the command failed to complete
+	for i := 0; i < 2; i++ {
+		err = <-outputErrors
+		if err != nil {
+			return fmt.Errorf("Failed to run command '%s': %s", commandText, err)
+		}
 	}
 
-	// TODO
-	// return cmd.Error()
+	exitCode := cmd.ExitCode()
+	if exitCode != 0 {
+		return &ExitError{
+			Command: commandText,
+			Status:  exitCode,
+		}
+	}
 	return nil
 }
 
+// ExitError is returned when the remote command completes with a non zero exit code
+type ExitError struct {
+	Command string
+	Status  int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("Failed to run command '%s' got exit code %d", e.Command, e.Status)
+}
+
+// ExitStatus returns the exit code of the remote command
+func (e *ExitError) ExitStatus() int {
+	return e.Status
+}
+
 // CloseShell closes the given WinRM Shell terminating any processes created within it
 func CloseShell(user string, password string, host string, port string, shellID string) error {
 	portNumber, err := parsePortNumber(port)